@@ -0,0 +1,355 @@
+// Package apptest helps test the app's handlers. It builds the configured Echo
+// app against a fake Cognito (mockcognito), logs users in to get a session
+// cookie or access token for them, and has assertions for the responses:
+//
+//	app := apptest.New(t, apptest.Options{})
+//	bob := models.User{ID: "1", Name: "Bob", Groups: []string{server.RoleAdmin}}
+//
+//	rec := app.Get("/admin", app.SessionCookie(t, bob))
+//	apptest.AssertStatus(t, rec, http.StatusOK)
+//	apptest.AssertRendered(t, rec, views.Admin(bob))
+//
+// Requests are served straight by the Echo app, without a listener, so tests
+// can run in parallel.
+package apptest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/a-h/templ"
+	"github.com/labstack/echo/v4"
+
+	"echo-cognito-auth/cognitoauth"
+	"echo-cognito-auth/config"
+	"echo-cognito-auth/mockcognito"
+	"echo-cognito-auth/models"
+	"echo-cognito-auth/server"
+)
+
+// CSRFField is the form field, and cookie, with the CSRF token for the forms on
+// the user pages.
+const CSRFField = "_csrf"
+
+// Options configure the app.
+type Options struct {
+	// Cognito configures the fake Cognito.
+	Cognito mockcognito.Options
+	// Configure changes the app's config, which starts out valid for the fake
+	// Cognito, with the memory session store.
+	Configure func(*config.Config)
+	// Logger defaults to logging to the test's log.
+	Logger *slog.Logger
+	// Assets serve the static assets. Defaults to the app's assets directory.
+	Assets http.FileSystem
+}
+
+// App is the app under test.
+type App struct {
+	Echo    *echo.Echo
+	Cognito *mockcognito.Server
+	Config  *config.Config
+}
+
+// New builds the app. It fails the test if it can't, and everything is closed
+// when the test finishes.
+func New(tb testing.TB, opts Options) *App {
+	tb.Helper()
+
+	cognito := mockcognito.New(opts.Cognito)
+	tb.Cleanup(cognito.Close)
+
+	cfg := newConfig(cognito)
+	if opts.Configure != nil {
+		opts.Configure(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		tb.Fatalf("apptest: %v", err)
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(testWriter{tb}, nil))
+	}
+
+	assets := opts.Assets
+	if assets == nil {
+		assets = http.Dir(assetsDir())
+	}
+
+	app, err := server.New(cfg, logger, assets, server.BuildInfo{Stage: cfg.Stage})
+	if err != nil {
+		tb.Fatalf("apptest: failed to build the app: %v", err)
+	}
+	tb.Cleanup(func() { app.Close() })
+
+	return &App{Echo: app.Echo, Cognito: cognito, Config: cfg}
+}
+
+// newConfig returns a valid config for the app on localhost, with the fake
+// Cognito.
+func newConfig(cognito *mockcognito.Server) *config.Config {
+	cfg := config.Defaults(config.StageDev)
+	cfg.Cognito = config.CognitoConfig{
+		ClientID:     cognito.ClientID,
+		ClientSecret: cognito.ClientSecret,
+		BaseURL:      cognito.URL,
+		IssuerURL:    cognito.IssuerURL(),
+		RedirectURI:  cfg.AppURL + cognitoauth.DefaultCallbackPath,
+		// Fail fast, the fake Cognito only fails when asked to
+		MaxRetries: -1,
+	}
+	cfg.Session.Keys = []config.SessionKey{{
+		Hash:       randomKey(config.MinSessionSecretLength),
+		Encryption: randomKey(config.SessionEncryptionKeyLength),
+	}}
+	return &cfg
+}
+
+// randomKey returns a random key of length characters.
+func randomKey(length int) string {
+	b := make([]byte, length/2)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// assetsDir is the app's assets directory, found from this file so it works
+// from any package's tests.
+func assetsDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "assets")
+}
+
+// testWriter writes the app's logs to the test's log.
+type testWriter struct {
+	tb testing.TB
+}
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.tb.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// addUser adds the user to the fake Cognito, with their name as the username
+// (which is their name for bearer tokens) or their ID if they don't have one.
+func (a *App) addUser(user models.User, password string) mockcognito.User {
+	username := user.Name
+	if username == "" {
+		username = user.ID
+	}
+
+	return a.Cognito.AddUser(mockcognito.User{
+		ID:       user.ID,
+		Username: username,
+		Password: password,
+		Name:     user.Name,
+		Groups:   user.Groups,
+	})
+}
+
+// SessionCookie logs the user in, with their groups as their roles, and returns
+// their session cookie. The user is added to the fake Cognito, and logs in
+// through the app's login and callback routes, so the session is just like a
+// real one. The user's ID is generated if it's empty.
+func (a *App) SessionCookie(tb testing.TB, user models.User) *http.Cookie {
+	tb.Helper()
+
+	attempt, authorizeURL := a.StartLogin(tb, nil)
+	callbackURL := a.CognitoLogin(tb, authorizeURL, user)
+
+	rec := a.Get(callbackURL.RequestURI(), attempt)
+	session := a.Cookie(rec)
+	if !isRedirect(rec.Code) || session == nil {
+		tb.Fatalf("apptest: login callback returned %d: %s", rec.Code, rec.Body.String())
+	}
+
+	return session
+}
+
+// StartLogin gets the app's login route, with the session cookie if it isn't
+// nil, like SessionCookie does. It returns the session cookie with the login
+// attempt in it, and the Cognito authorize URL the app redirected to.
+func (a *App) StartLogin(tb testing.TB, session *http.Cookie) (*http.Cookie, *url.URL) {
+	tb.Helper()
+
+	rec := a.Get(cognitoauth.DefaultLoginPath, session)
+	authorizeURL, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+	if !isRedirect(rec.Code) || err != nil {
+		tb.Fatalf("apptest: login returned %d to %q, want a redirect to Cognito", rec.Code, rec.Header().Get(echo.HeaderLocation))
+	}
+	attempt := a.Cookie(rec)
+	if attempt == nil {
+		tb.Fatalf("apptest: login didn't set the session cookie")
+	}
+
+	return attempt, authorizeURL
+}
+
+// CognitoLogin logs the user in to the fake Cognito at the authorize URL from
+// StartLogin, and returns the app's callback URL it redirected to, with the
+// authorization code and state. The user is added to the fake Cognito.
+func (a *App) CognitoLogin(tb testing.TB, authorizeURL *url.URL, user models.User) *url.URL {
+	tb.Helper()
+
+	password := rand.Text()
+	cognitoUser := a.addUser(user, password)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.PostForm(a.Cognito.URL+mockcognito.PathLogin+"?"+authorizeURL.RawQuery, url.Values{
+		"username": {cognitoUser.Username},
+		"password": {password},
+	})
+	if err != nil {
+		tb.Fatalf("apptest: failed to log in to Cognito: %v", err)
+	}
+	resp.Body.Close()
+	callbackURL, err := url.Parse(resp.Header.Get("Location"))
+	if !isRedirect(resp.StatusCode) || err != nil {
+		tb.Fatalf("apptest: Cognito login returned %d to %q, want a redirect to the app", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	return callbackURL
+}
+
+// Cookie returns the session cookie the response set, or nil. Regenerating the
+// session sets it twice, deleting the old one first, so it's the last one.
+func (a *App) Cookie(rec *httptest.ResponseRecorder) *http.Cookie {
+	var session *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == a.Config.Session.Name() && c.MaxAge >= 0 {
+			session = c
+		}
+	}
+	return session
+}
+
+// BearerToken returns an access token for the user, with their groups as their
+// roles, for the API routes. The user is added to the fake Cognito, and their
+// ID is generated if it's empty.
+func (a *App) BearerToken(tb testing.TB, user models.User, scopes ...string) string {
+	tb.Helper()
+
+	cognitoUser := a.addUser(user, rand.Text())
+	token, err := a.Cognito.IssueAccessToken(cognitoUser.Username, scopes...)
+	if err != nil {
+		tb.Fatalf("apptest: %v", err)
+	}
+	return token
+}
+
+// Serve serves the request.
+func (a *App) Serve(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	a.Echo.ServeHTTP(rec, req)
+	return rec
+}
+
+// Get gets the path like a browser, with the session cookie if it isn't nil.
+// Like the other request helpers, the path is on the AppURL, so the app's
+// absolute URLs (e.g. its logout URL) have the host in the config.
+func (a *App) Get(path string, session *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, a.Config.AppURL+path, nil)
+	req.Header.Set(echo.HeaderAccept, echo.MIMETextHTML)
+	if session != nil {
+		req.AddCookie(session)
+	}
+	return a.Serve(req)
+}
+
+// GetWithToken gets the API path with the bearer access token.
+func (a *App) GetWithToken(path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, a.Config.AppURL+path, nil)
+	req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	return a.Serve(req)
+}
+
+// PostForm posts the form to the path like a browser, with the session cookie
+// if it isn't nil, and a CSRF token.
+func (a *App) PostForm(path string, form url.Values, session *http.Cookie) *httptest.ResponseRecorder {
+	csrfToken := rand.Text()
+	form = cloneValues(form)
+	form.Set(CSRFField, csrfToken)
+
+	req := httptest.NewRequest(http.MethodPost, a.Config.AppURL+path, strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.Header.Set(echo.HeaderAccept, echo.MIMETextHTML)
+	req.AddCookie(&http.Cookie{Name: CSRFField, Value: csrfToken})
+	if session != nil {
+		req.AddCookie(session)
+	}
+	return a.Serve(req)
+}
+
+func cloneValues(v url.Values) url.Values {
+	clone := url.Values{}
+	for name, values := range v {
+		clone[name] = values
+	}
+	return clone
+}
+
+// AssertStatus checks the response's status.
+func AssertStatus(tb testing.TB, rec *httptest.ResponseRecorder, want int) {
+	tb.Helper()
+
+	if rec.Code != want {
+		tb.Errorf("got status %d, want %d; body: %s", rec.Code, want, rec.Body.String())
+	}
+}
+
+// AssertRedirect checks the response redirects to the location. A location
+// without a query matches any query, e.g. "/login" matches
+// "/login?return_to=%2Fuser".
+func AssertRedirect(tb testing.TB, rec *httptest.ResponseRecorder, location string) {
+	tb.Helper()
+
+	got := rec.Header().Get(echo.HeaderLocation)
+	if !isRedirect(rec.Code) {
+		tb.Errorf("got status %d, want a redirect to %q", rec.Code, location)
+		return
+	}
+	if !strings.Contains(location, "?") {
+		got, _, _ = strings.Cut(got, "?")
+	}
+	if got != location {
+		tb.Errorf("got a redirect to %q, want %q", rec.Header().Get(echo.HeaderLocation), location)
+	}
+}
+
+// AssertContains checks the response body contains the text.
+func AssertContains(tb testing.TB, rec *httptest.ResponseRecorder, text string) {
+	tb.Helper()
+
+	if !strings.Contains(rec.Body.String(), text) {
+		tb.Errorf("body doesn't contain %q; body: %s", text, rec.Body.String())
+	}
+}
+
+// AssertRendered checks the response body contains the component, rendered
+// with the data the handler should have used. Whole pages must match exactly.
+func AssertRendered(tb testing.TB, rec *httptest.ResponseRecorder, component templ.Component) {
+	tb.Helper()
+
+	var want strings.Builder
+	if err := component.Render(context.Background(), &want); err != nil {
+		tb.Fatalf("failed to render the component: %v", err)
+	}
+
+	if !strings.Contains(rec.Body.String(), want.String()) {
+		tb.Errorf("body doesn't contain the rendered component\n got: %s\nwant: %s", rec.Body.String(), want.String())
+	}
+}
+
+func isRedirect(status int) bool {
+	return status >= 300 && status < 400
+}
@@ -0,0 +1,118 @@
+package cognitoauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+
+	"echo-cognito-auth/models"
+)
+
+var (
+	errClientNotAllowed  = errors.New("token was issued to a client that is not allowed")
+	errInsufficientScope = errors.New("token does not have the required scopes")
+)
+
+// AccessTokenClaims are the claims we use from a Cognito access token. See:
+// https://docs.aws.amazon.com/cognito/latest/developerguide/amazon-cognito-user-pools-using-the-access-token.html
+type AccessTokenClaims struct {
+	TokenUse string   `json:"token_use"`
+	ClientID string   `json:"client_id"`
+	Scope    string   `json:"scope"`
+	Username string   `json:"username"`
+	Groups   []string `json:"cognito:groups"`
+	jwt.RegisteredClaims
+}
+
+// hasScopes reports whether the token was granted all of the scopes.
+func (c *AccessTokenClaims) hasScopes(scopes []string) bool {
+	granted := strings.Fields(c.Scope)
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// verifyAccessToken checks the access token's signature, issuer, token_use,
+// expiry and issued at time, that it was issued to one of the allowed app
+// clients, and that it has all of the required scopes.
+func (v *jwtVerifier) verifyAccessToken(ctx context.Context, accessToken string, allowedClientIDs, scopes []string) (*AccessTokenClaims, error) {
+	claims := &AccessTokenClaims{}
+	_, err := jwt.ParseWithClaims(accessToken, claims, v.keyFunc(ctx),
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(v.issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(tokenLeeway),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access token: %w", err)
+	}
+
+	if claims.TokenUse != "access" {
+		return nil, fmt.Errorf("invalid access token: %w: %q", errWrongTokenUse, claims.TokenUse)
+	}
+
+	if !slices.Contains(allowedClientIDs, claims.ClientID) {
+		return nil, fmt.Errorf("invalid access token: %w: %s", errClientNotAllowed, claims.ClientID)
+	}
+
+	if !claims.hasScopes(scopes) {
+		return nil, fmt.Errorf("invalid access token: %w: %v", errInsufficientScope, scopes)
+	}
+
+	return claims, nil
+}
+
+// userFromAccessTokenClaims builds our user from verified access token claims.
+// Access tokens don't include the user's name, so we use their username.
+func userFromAccessTokenClaims(claims *AccessTokenClaims) models.User {
+	return models.User{
+		ID:     claims.Subject,
+		Name:   claims.Username,
+		Groups: claims.Groups,
+	}
+}
+
+// RequireBearerToken is a middleware for API routes, authenticating requests
+// with a Cognito access token in the Authorization header instead of the
+// session. The token must have all of the scopes. It puts the user in the
+// context just like AddUserToContext, so handlers work the same for both.
+func (a *Auth) RequireBearerToken(scopes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth := c.Request().Header.Get(echo.HeaderAuthorization)
+			scheme, token, ok := strings.Cut(auth, " ")
+			if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer`)
+				return echo.NewHTTPError(http.StatusUnauthorized, "A bearer access token is required")
+			}
+
+			claims, err := a.verifier.verifyAccessToken(c.Request().Context(), token, a.cfg.APIClientIDs, scopes)
+			if errors.Is(err, errInsufficientScope) {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate,
+					fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, strings.Join(scopes, " ")))
+				return echo.NewHTTPError(http.StatusForbidden, "The access token does not have the required scopes")
+			}
+			if err != nil {
+				a.logger.Info("RequireBearerToken: rejected access token", "error", err)
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				return echo.NewHTTPError(http.StatusUnauthorized, "The access token is invalid or expired")
+			}
+
+			user := userFromAccessTokenClaims(claims)
+			SetUser(c, &user)
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package cognitoauth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultClientTimeout is the timeout for each attempt at a request. It's
+	// kept short, as Lambda functions are typically limited to a few seconds.
+	DefaultClientTimeout = 3 * time.Second
+	// DefaultMaxRetries is how many times idempotent requests are retried.
+	DefaultMaxRetries = 2
+	// DefaultRetryBaseDelay is the base of the exponential retry backoff.
+	DefaultRetryBaseDelay = 100 * time.Millisecond
+	// DefaultBreakerThreshold is how many failures in a row open the circuit.
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is how long the circuit stays open.
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned instead of making a request while the provider
+// is failing, so requests fail fast rather than each waiting for a timeout.
+var ErrCircuitOpen = errors.New("cognitoauth: circuit breaker open, not calling provider")
+
+// StatusError is a non-200 response that isn't an OAuth error response.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("request returned non-200 status: %d, body: %s", e.StatusCode, e.Body)
+}
+
+// ClientOptions configures a Client. Zero values get the defaults.
+type ClientOptions struct {
+	// HTTPClient defaults to an http.Client with Timeout.
+	HTTPClient *http.Client
+	// Timeout is for each attempt, not including retries.
+	Timeout time.Duration
+	// MaxRetries for idempotent requests. Negative disables retries.
+	MaxRetries       int
+	RetryBaseDelay   time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	Logger           *slog.Logger
+}
+
+// Client makes the HTTP requests to Cognito. It retries idempotent requests
+// that fail with a network error, 429 or 5xx (with jittered exponential
+// backoff), and has a circuit breaker that stops calling Cognito for a while
+// after repeated failures. A Client is safe for concurrent use, and should be
+// shared.
+type Client struct {
+	httpClient     *http.Client
+	maxRetries     int
+	retryBaseDelay time.Duration
+	breaker        *circuitBreaker
+	logger         *slog.Logger
+}
+
+func NewClient(opts ClientOptions) *Client {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultClientTimeout
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: opts.Timeout}
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultMaxRetries
+	} else if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	}
+	if opts.RetryBaseDelay == 0 {
+		opts.RetryBaseDelay = DefaultRetryBaseDelay
+	}
+	if opts.BreakerThreshold == 0 {
+		opts.BreakerThreshold = DefaultBreakerThreshold
+	}
+	if opts.BreakerCooldown == 0 {
+		opts.BreakerCooldown = DefaultBreakerCooldown
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	return &Client{
+		httpClient:     opts.HTTPClient,
+		maxRetries:     opts.MaxRetries,
+		retryBaseDelay: opts.RetryBaseDelay,
+		breaker: &circuitBreaker{
+			threshold: opts.BreakerThreshold,
+			cooldown:  opts.BreakerCooldown,
+		},
+		logger: opts.Logger,
+	}
+}
+
+// get sends a GET request, which is always retried on failure.
+func (c *Client) get(ctx context.Context, endpoint string) ([]byte, error) {
+	return c.do(ctx, true, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	})
+}
+
+// postForm sends the form data, with basic auth if username is set. Only
+// idempotent requests are retried.
+func (c *Client) postForm(ctx context.Context, endpoint string, form url.Values, username, password string, idempotent bool) ([]byte, error) {
+	return c.do(ctx, idempotent, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		return req, nil
+	})
+}
+
+// postAWSJSON calls an AWS JSON 1.1 API action (e.g. the Cognito user pools
+// API) with the JSON encoded body. Only idempotent requests are retried.
+func (c *Client) postAWSJSON(ctx context.Context, endpoint, target string, body []byte, idempotent bool) ([]byte, error) {
+	return c.do(ctx, idempotent, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Add("Content-Type", "application/x-amz-json-1.1")
+		req.Header.Add("X-Amz-Target", target)
+		return req, nil
+	})
+}
+
+// do sends the request made by newRequest, retrying if it's idempotent, and
+// returns the body of a 200 response. Other responses are returned as an
+// *OAuthError if the body is an OAuth error, otherwise a *StatusError.
+func (c *Client) do(ctx context.Context, idempotent bool, newRequest func(context.Context) (*http.Request, error)) ([]byte, error) {
+	attempts := 1
+	if idempotent {
+		attempts += c.maxRetries
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if err := c.wait(ctx, attempt); err != nil {
+				return nil, err
+			}
+		}
+
+		if !c.breaker.allow() {
+			return nil, ErrCircuitOpen
+		}
+
+		var body []byte
+		var retryable bool
+		body, retryable, err = c.send(ctx, newRequest)
+		switch {
+		case ctx.Err() != nil:
+			// The caller gave up, which says nothing about Cognito
+			c.breaker.cancelled()
+		case retryable:
+			c.breaker.failure()
+		default:
+			c.breaker.success()
+		}
+
+		if !retryable || ctx.Err() != nil {
+			return body, err
+		}
+
+		c.logger.Warn("Client: request failed", "attempt", attempt+1, "attempts", attempts, "error", err)
+	}
+
+	return nil, err
+}
+
+// send makes a single attempt at the request. It reports whether the failure
+// (if any) is worth retrying, i.e. a network error, 429 or 5xx.
+func (c *Client) send(ctx context.Context, newRequest func(context.Context) (*http.Request, error)) ([]byte, bool, error) {
+	req, err := newRequest(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, true, fmt.Errorf("request to %s failed: %w", req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to read response from %s: %w", req.URL.Path, err)
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		return body, false, nil
+	}
+
+	retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+	if oauthErr := parseOAuthError(resp.StatusCode, body); oauthErr != nil {
+		return nil, retryable, oauthErr
+	}
+	return nil, retryable, &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+}
+
+// wait sleeps before a retry, using exponential backoff with full jitter.
+func (c *Client) wait(ctx context.Context, attempt int) error {
+	backoff := c.retryBaseDelay << (attempt - 1)
+	timer := time.NewTimer(rand.N(backoff) + 1)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// circuitBreaker opens after threshold failures in a row, and rejects requests
+// until cooldown has passed. Then it lets a single request through to test the
+// provider, closing again if it succeeds.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+
+	b.probing = true
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+}
+
+// cancelled ends a request that was cancelled, without counting it either way.
+func (b *circuitBreaker) cancelled() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
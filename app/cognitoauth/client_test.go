@@ -0,0 +1,65 @@
+package cognitoauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientBreakerIgnoresCancelledRequests(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("slow") {
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	client := NewClient(ClientOptions{MaxRetries: -1, BreakerThreshold: 2, BreakerCooldown: time.Hour})
+
+	for range 3 {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := client.get(ctx, srv.URL+"?slow")
+		cancel()
+		if err == nil {
+			t.Fatal("get() with a cancelled context succeeded")
+		}
+	}
+
+	body, err := client.get(context.Background(), srv.URL)
+	if errors.Is(err, ErrCircuitOpen) {
+		t.Fatal("get() = ErrCircuitOpen after cancelled requests, want them not counted")
+	}
+	if err != nil || string(body) != "ok" {
+		t.Fatalf("get() = %q, %v, want ok", body, err)
+	}
+}
+
+func TestClientBreakerOpensOnFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	client := NewClient(ClientOptions{MaxRetries: -1, BreakerThreshold: 2, BreakerCooldown: time.Hour})
+
+	for range 2 {
+		var statusErr *StatusError
+		if _, err := client.get(context.Background(), srv.URL); !errors.As(err, &statusErr) {
+			t.Fatalf("get() error = %v, want a StatusError", err)
+		}
+	}
+
+	if _, err := client.get(context.Background(), srv.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("get() error = %v, want ErrCircuitOpen", err)
+	}
+}
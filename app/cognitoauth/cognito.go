@@ -0,0 +1,248 @@
+package cognitoauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"echo-cognito-auth/models"
+)
+
+// TokenResponse represents the response from Cognito token endpoint
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	TokenType    string `json:"token_type"`
+}
+
+// OAuthError is an OAuth error response from a Cognito endpoint, e.g.
+// {"error":"invalid_grant"}. It matches the Err* values below with errors.Is,
+// by Code.
+type OAuthError struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// The OAuth error codes, see:
+// https://datatracker.ietf.org/doc/html/rfc6749#section-4.1.2.1 and
+// https://datatracker.ietf.org/doc/html/rfc6749#section-5.2
+var (
+	ErrInvalidRequest       = &OAuthError{Code: "invalid_request"}
+	ErrInvalidClient        = &OAuthError{Code: "invalid_client"}
+	ErrInvalidGrant         = &OAuthError{Code: "invalid_grant"}
+	ErrUnauthorizedClient   = &OAuthError{Code: "unauthorized_client"}
+	ErrUnsupportedGrantType = &OAuthError{Code: "unsupported_grant_type"}
+	ErrInvalidScope         = &OAuthError{Code: "invalid_scope"}
+	ErrAccessDenied         = &OAuthError{Code: "access_denied"}
+	ErrUnsupportedTokenType = &OAuthError{Code: "unsupported_token_type"}
+
+	ErrUnsupportedResponseType = &OAuthError{Code: "unsupported_response_type"}
+	ErrServerError             = &OAuthError{Code: "server_error"}
+	ErrTemporarilyUnavailable  = &OAuthError{Code: "temporarily_unavailable"}
+)
+
+func (e *OAuthError) Error() string {
+	msg := "cognito returned " + e.Code
+	// Errors from the login redirect have no status
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (status %d)", e.StatusCode)
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+func (e *OAuthError) Is(target error) bool {
+	t, ok := target.(*OAuthError)
+	return ok && t.Code == e.Code
+}
+
+// parseOAuthError returns the OAuth error in the response body, or nil if it
+// isn't one.
+func parseOAuthError(statusCode int, body []byte) *OAuthError {
+	oauthErr := &OAuthError{StatusCode: statusCode}
+	if err := json.Unmarshal(body, oauthErr); err != nil || oauthErr.Code == "" {
+		return nil
+	}
+	return oauthErr
+}
+
+// exchangeCodeForTokens exchanges the authorization code for access and ID tokens.
+// The codeVerifier is the PKCE verifier whose challenge was sent with the login.
+func (a *Auth) exchangeCodeForTokens(ctx context.Context, code, codeVerifier string) (*TokenResponse, error) {
+	// Prepare form data
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("client_id", a.cfg.ClientID)
+	data.Set("code", code)
+	data.Set("redirect_uri", a.cfg.RedirectURI)
+	data.Set("code_verifier", codeVerifier)
+	if a.cfg.ClientSecret != "" {
+		data.Set("client_secret", a.cfg.ClientSecret)
+	}
+
+	// Codes are single use, so this can't be retried
+	return a.postTokenRequest(ctx, data, false)
+}
+
+// refreshTokens uses the refresh token to get new access and ID tokens. Cognito
+// doesn't return a new refresh token unless refresh token rotation is enabled.
+func (a *Auth) refreshTokens(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+	data := url.Values{}
+	data.Set("grant_type", "refresh_token")
+	data.Set("client_id", a.cfg.ClientID)
+	data.Set("refresh_token", refreshToken)
+	if a.cfg.ClientSecret != "" {
+		data.Set("client_secret", a.cfg.ClientSecret)
+	}
+
+	// Refresh tokens can be reused (and with rotation, Cognito allows reuse of
+	// the previous one for a short grace period), so this can be retried
+	return a.postTokenRequest(ctx, data, true)
+}
+
+// postTokenRequest sends the form data to the Cognito token endpoint.
+func (a *Auth) postTokenRequest(ctx context.Context, data url.Values, idempotent bool) (*TokenResponse, error) {
+	body, err := a.client.postForm(ctx, a.endpoints.Token, data, "", "", idempotent)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+
+	// Parse response
+	var tokenResponse TokenResponse
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse token response: %w", err)
+	}
+
+	return &tokenResponse, nil
+}
+
+// revokeRefreshToken revokes the refresh token, and the access tokens issued
+// from it. See:
+// https://docs.aws.amazon.com/cognito/latest/developerguide/revocation-endpoint.html
+func (a *Auth) revokeRefreshToken(ctx context.Context, refreshToken string) error {
+	if a.endpoints.Revocation == "" {
+		return errors.New("no revocation endpoint")
+	}
+
+	data := url.Values{}
+	data.Set("token", refreshToken)
+	data.Set("client_id", a.cfg.ClientID)
+
+	// Clients with a secret must authenticate with basic auth for revocation
+	var username string
+	if a.cfg.ClientSecret != "" {
+		username = a.cfg.ClientID
+	}
+
+	// Not retried, so logging out doesn't wait on a slow Cognito
+	if _, err := a.client.postForm(ctx, a.endpoints.Revocation, data, username, a.cfg.ClientSecret, false); err != nil {
+		return fmt.Errorf("revoke request failed: %w", err)
+	}
+
+	return nil
+}
+
+// globalSignOut signs the user out of Cognito on every device, by invalidating
+// all of their refresh tokens (and the access tokens issued from them). The
+// access token needs the aws.cognito.signin.user.admin scope. See:
+// https://docs.aws.amazon.com/cognito-user-identity-pools/latest/APIReference/API_GlobalSignOut.html
+func (a *Auth) globalSignOut(ctx context.Context, accessToken string) error {
+	issuer, err := url.Parse(a.endpoints.Issuer)
+	if err != nil || !strings.HasPrefix(issuer.Host, "cognito-idp.") {
+		return errors.New("global sign out is only supported for Cognito user pools")
+	}
+
+	body, err := json.Marshal(map[string]string{"AccessToken": accessToken})
+	if err != nil {
+		return err
+	}
+
+	endpoint := issuer.Scheme + "://" + issuer.Host + "/"
+	if _, err := a.client.postAWSJSON(ctx, endpoint, "AWSCognitoIdentityProviderService.GlobalSignOut", body, true); err != nil {
+		return fmt.Errorf("global sign out request failed: %w", err)
+	}
+
+	return nil
+}
+
+// signOutOfCognito signs the user out of Cognito everywhere with globalSignOut.
+// The session doesn't keep an access token, so it gets a new one first.
+func (a *Auth) signOutOfCognito(ctx context.Context, refreshToken string) error {
+	tokens, err := a.refreshTokens(ctx, refreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to get an access token: %w", err)
+	}
+	return a.globalSignOut(ctx, tokens.AccessToken)
+}
+
+// userFromClaims builds our user from verified ID token claims.
+func userFromClaims(claims *IDTokenClaims) models.User {
+	return models.User{
+		ID:     claims.Subject,
+		Name:   claims.Name,
+		Groups: claims.Groups,
+	}
+}
+
+// hostedLoginURL builds the URL for the Cognito managed login page. The
+// state is echoed back to our callback so we can tie the response to the login
+// attempt that started it, the PKCE challenge binds the authorization code to
+// the verifier we keep for that attempt, and the nonce binds the ID token to it.
+func (a *Auth) hostedLoginURL(attempt *loginAttempt) string {
+	u, err := url.Parse(a.endpoints.Authorization)
+	if err != nil {
+		a.logger.Error("Error parsing authorization endpoint", "error", err)
+		return ""
+	}
+
+	params := url.Values{}
+	params.Add("response_type", "code")
+	params.Add("client_id", a.cfg.ClientID)
+	params.Add("redirect_uri", a.cfg.RedirectURI)
+	params.Add("state", attempt.State)
+	params.Add("code_challenge", attempt.codeChallenge())
+	params.Add("code_challenge_method", "S256")
+	params.Add("nonce", attempt.Nonce)
+	params.Add("scope", strings.Join(a.cfg.Scopes, " "))
+	u.RawQuery = params.Encode()
+
+	return u.String()
+}
+
+func (a *Auth) hostedLogoutURL(host string) string {
+	if a.endpoints.EndSession == "" {
+		return ""
+	}
+
+	u, err := url.Parse(a.endpoints.EndSession)
+	if err != nil {
+		a.logger.Error("Error parsing end session endpoint", "error", err)
+		return ""
+	}
+
+	// This will send the user back to the home page of the app after logging out.
+	// Alternatively, if you want to send them back to the login/signup page, you
+	// would instead set all the same params as the login URL, and remove the
+	// logout_uri parameter.
+	var logoutURI string
+	if strings.Contains(host, "localhost") {
+		logoutURI = "http://" + host
+	} else {
+		logoutURI = "https://" + host
+	}
+
+	params := url.Values{}
+	params.Add("client_id", a.cfg.ClientID)
+	params.Add("logout_uri", logoutURI)
+	u.RawQuery = params.Encode()
+
+	return u.String()
+}
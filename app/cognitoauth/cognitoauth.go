@@ -0,0 +1,230 @@
+// Package cognitoauth adds AWS Cognito login to an Echo app, using the Cognito
+// managed login pages and the OAuth authorization code flow (with PKCE). It
+// registers the login, logout and callback routes, keeps the logged in user in
+// the session (using the echo-contrib session middleware, which must run before
+// it), and provides middleware to require a logged in user, roles (Cognito
+// groups), or a bearer access token for APIs.
+package cognitoauth
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"echo-cognito-auth/models"
+)
+
+const (
+	// DefaultLoginPath, DefaultLogoutPath, etc. are the default routes
+	// registered by New, relative to the router they're added to.
+	DefaultLoginPath              = "/login"
+	DefaultLogoutPath             = "/logout"
+	DefaultCallbackPath           = "/auth/cognito/callback"
+	DefaultBackChannelLogoutPath  = "/auth/cognito/backchannel-logout"
+	DefaultFrontChannelLogoutPath = "/auth/cognito/frontchannel-logout"
+
+	// DefaultSessionName is the default name of the session we store the user in.
+	DefaultSessionName = "session"
+
+	// ScopeUserAdmin is the scope that lets an access token call the Cognito
+	// API for its own user, which SignOutEverywhereHandler needs.
+	ScopeUserAdmin = "aws.cognito.signin.user.admin"
+
+	// ReturnToParam is the login route query parameter with the local path to
+	// return to after logging in.
+	ReturnToParam = "return_to"
+
+	sessionUserKey         = "user"
+	sessionLoginAttemptKey = "login_attempt"
+	sessionTokensKey       = "tokens"
+	contextUserKey         = "user"
+)
+
+// DefaultScopes are the scopes requested at login by default.
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// Config configures the Cognito auth.
+type Config struct {
+	// ClientID is the Cognito user pool app client ID.
+	ClientID string
+	// ClientSecret is the app client secret. Optional: public app clients (no
+	// secret) rely on PKCE alone.
+	ClientSecret string
+	// BaseURL is the user pool domain, e.g. https://<domain>.auth.<region>.amazoncognito.com
+	// The endpoints are discovered from the IssuerURL, so this is only needed
+	// as a fallback when discovery fails or is disabled.
+	BaseURL string
+	// RedirectURI is the full URL of the callback route, which must be one of
+	// the app client's callback URLs.
+	RedirectURI string
+	// IssuerURL is the user pool issuer, https://cognito-idp.<region>.amazonaws.com/<poolId>
+	// (or any other OIDC provider's issuer).
+	IssuerURL string
+	// DisableDiscovery skips loading the issuer's OIDC discovery document, and
+	// uses Cognito's URL layout under BaseURL instead.
+	DisableDiscovery bool
+	// DiscoveryTimeout defaults to DefaultDiscoveryTimeout.
+	DiscoveryTimeout time.Duration
+	// Client makes the requests to Cognito. Defaults to a Client with the
+	// default options.
+	Client *Client
+	// Scopes requested at login. Defaults to DefaultScopes. Include
+	// ScopeUserAdmin to sign users out of Cognito on every device when they
+	// sign out everywhere.
+	Scopes []string
+	// APIClientIDs are the app clients whose access tokens RequireBearerToken
+	// accepts. Defaults to just ClientID.
+	APIClientIDs []string
+
+	// Paths for the routes New registers. Default to DefaultLoginPath, etc.
+	LoginPath              string
+	LogoutPath             string
+	CallbackPath           string
+	BackChannelLogoutPath  string
+	FrontChannelLogoutPath string
+
+	// SessionName is the name of the session the user is stored in. Defaults to
+	// DefaultSessionName.
+	SessionName string
+	// IdleTimeout logs the user out after this long without a request, and
+	// MaxSessionAge this long after they logged in. Zero disables either.
+	IdleTimeout   time.Duration
+	MaxSessionAge time.Duration
+
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+
+	// OnLogin, if set, is called after a user logs in and before they are stored
+	// in the session. It can update the user (e.g. with details from the app's
+	// own records), or return an error to stop the login.
+	OnLogin func(c echo.Context, user *models.User, tokens *TokenResponse) error
+	// OnLogout, if set, is called when a logged in user logs out.
+	OnLogout func(c echo.Context, user *models.User)
+}
+
+// Router is where New registers its routes, i.e. an *echo.Echo or *echo.Group.
+type Router interface {
+	GET(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+	POST(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+}
+
+// Auth handles the Cognito login flow and provides the auth middleware.
+type Auth struct {
+	cfg       Config
+	logger    *slog.Logger
+	client    *Client
+	endpoints Endpoints
+	verifier  *jwtVerifier
+
+	// loginPath is the full path of the login route, for redirects to it
+	loginPath string
+
+	logoutTokens *seenTokens
+}
+
+func init() {
+	gob.Register(models.User{})
+	gob.Register(loginAttempt{})
+	gob.Register(sessionTokens{})
+}
+
+// New creates the Cognito auth from the config, and registers its login,
+// logout, callback and single logout routes on the router.
+func New(r Router, cfg Config) (*Auth, error) {
+	var missing []string
+	if cfg.ClientID == "" {
+		missing = append(missing, "ClientID")
+	}
+	if cfg.RedirectURI == "" {
+		missing = append(missing, "RedirectURI")
+	}
+	if cfg.IssuerURL == "" {
+		missing = append(missing, "IssuerURL")
+	}
+	if len(missing) > 0 {
+		return nil, errors.New("cognitoauth: missing config: " + strings.Join(missing, ", "))
+	}
+
+	if len(cfg.APIClientIDs) == 0 {
+		cfg.APIClientIDs = []string{cfg.ClientID}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = DefaultScopes
+	}
+	if cfg.LoginPath == "" {
+		cfg.LoginPath = DefaultLoginPath
+	}
+	if cfg.LogoutPath == "" {
+		cfg.LogoutPath = DefaultLogoutPath
+	}
+	if cfg.CallbackPath == "" {
+		cfg.CallbackPath = DefaultCallbackPath
+	}
+	if cfg.BackChannelLogoutPath == "" {
+		cfg.BackChannelLogoutPath = DefaultBackChannelLogoutPath
+	}
+	if cfg.FrontChannelLogoutPath == "" {
+		cfg.FrontChannelLogoutPath = DefaultFrontChannelLogoutPath
+	}
+	if cfg.SessionName == "" {
+		cfg.SessionName = DefaultSessionName
+	}
+	if cfg.DiscoveryTimeout == 0 {
+		cfg.DiscoveryTimeout = DefaultDiscoveryTimeout
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if cfg.Client == nil {
+		cfg.Client = NewClient(ClientOptions{Logger: cfg.Logger})
+	}
+
+	endpoints := cognitoEndpoints(cfg.BaseURL, cfg.IssuerURL)
+	if !cfg.DisableDiscovery {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.DiscoveryTimeout)
+		discovered, err := discoverEndpoints(ctx, cfg.Client, cfg.IssuerURL)
+		cancel()
+		if err != nil {
+			cfg.Logger.Error("New: OIDC discovery failed, using Cognito URL layout", "issuer", cfg.IssuerURL, "error", err)
+		} else {
+			endpoints = discovered.withFallback(endpoints)
+		}
+	}
+	if missing := endpoints.missing(); len(missing) > 0 {
+		return nil, errors.New("cognitoauth: no endpoints for: " + strings.Join(missing, ", ") + " (set BaseURL, or check IssuerURL)")
+	}
+
+	a := &Auth{
+		cfg:       cfg,
+		logger:    cfg.Logger,
+		client:    cfg.Client,
+		endpoints: endpoints,
+		verifier:  newJWTVerifier(endpoints.Issuer, endpoints.JWKS, cfg.ClientID, cfg.Client, cfg.Logger),
+
+		logoutTokens: newSeenTokens(),
+	}
+
+	a.loginPath = r.GET(cfg.LoginPath, a.LoginHandler).Path
+	r.GET(cfg.CallbackPath, a.CallbackHandler)
+	r.GET(cfg.LogoutPath, a.LogoutHandler)
+	r.POST(cfg.BackChannelLogoutPath, a.BackChannelLogoutHandler)
+	r.GET(cfg.FrontChannelLogoutPath, a.FrontChannelLogoutHandler)
+
+	return a, nil
+}
+
+// Endpoints returns the provider endpoints in use.
+func (a *Auth) Endpoints() Endpoints {
+	return a.endpoints
+}
+
+// CheckJWKS fetches the user pool's JWKS, to check Cognito can be reached (e.g.
+// for a readiness check), and keeps the keys for verifying tokens.
+func (a *Auth) CheckJWKS(ctx context.Context) error {
+	return a.verifier.checkKeys(ctx)
+}
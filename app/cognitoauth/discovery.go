@@ -0,0 +1,119 @@
+package cognitoauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDiscoveryTimeout is how long New waits for the discovery document.
+const DefaultDiscoveryTimeout = 5 * time.Second
+
+// Endpoints are the provider's OAuth/OIDC endpoints. They are loaded from the
+// issuer's OpenID Connect discovery document, see:
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type Endpoints struct {
+	Issuer        string `json:"issuer"`
+	Authorization string `json:"authorization_endpoint"`
+	Token         string `json:"token_endpoint"`
+	UserInfo      string `json:"userinfo_endpoint"`
+	Revocation    string `json:"revocation_endpoint"`
+	JWKS          string `json:"jwks_uri"`
+	EndSession    string `json:"end_session_endpoint"`
+}
+
+// discoveryCache holds the endpoints already discovered, by issuer, so they're
+// only fetched once per process.
+var discoveryCache = struct {
+	sync.Mutex
+	endpoints map[string]Endpoints
+}{endpoints: map[string]Endpoints{}}
+
+// cognitoEndpoints returns the endpoints using Cognito's URL layout, for the
+// user pool domain (baseURL) and issuer. This is the fallback for any endpoints
+// discovery doesn't provide.
+func cognitoEndpoints(baseURL, issuer string) Endpoints {
+	e := Endpoints{
+		Issuer: issuer,
+		JWKS:   issuer + "/.well-known/jwks.json",
+	}
+
+	if baseURL != "" {
+		baseURL = strings.TrimSuffix(baseURL, "/")
+		e.Authorization = baseURL + "/login"
+		e.Token = baseURL + "/oauth2/token"
+		e.UserInfo = baseURL + "/oauth2/userInfo"
+		e.Revocation = baseURL + "/oauth2/revoke"
+		e.EndSession = baseURL + "/logout"
+	}
+
+	return e
+}
+
+// withFallback fills in any missing endpoints from the fallback.
+func (e Endpoints) withFallback(fallback Endpoints) Endpoints {
+	fill := func(value *string, fallback string) {
+		if *value == "" {
+			*value = fallback
+		}
+	}
+
+	fill(&e.Issuer, fallback.Issuer)
+	fill(&e.Authorization, fallback.Authorization)
+	fill(&e.Token, fallback.Token)
+	fill(&e.UserInfo, fallback.UserInfo)
+	fill(&e.Revocation, fallback.Revocation)
+	fill(&e.JWKS, fallback.JWKS)
+	fill(&e.EndSession, fallback.EndSession)
+
+	return e
+}
+
+// missing returns the names of the endpoints the login flow needs that aren't set.
+func (e Endpoints) missing() []string {
+	var missing []string
+	if e.Authorization == "" {
+		missing = append(missing, "authorization")
+	}
+	if e.Token == "" {
+		missing = append(missing, "token")
+	}
+	if e.JWKS == "" {
+		missing = append(missing, "jwks")
+	}
+
+	return missing
+}
+
+// discoverEndpoints loads the endpoints from the issuer's discovery document,
+// or from the cache if they've already been loaded.
+func discoverEndpoints(ctx context.Context, client *Client, issuer string) (Endpoints, error) {
+	discoveryCache.Lock()
+	defer discoveryCache.Unlock()
+
+	if e, ok := discoveryCache.endpoints[issuer]; ok {
+		return e, nil
+	}
+
+	discoveryURL := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	body, err := client.get(ctx, discoveryURL)
+	if err != nil {
+		return Endpoints{}, fmt.Errorf("discovery request failed: %w", err)
+	}
+
+	var e Endpoints
+	if err := json.Unmarshal(body, &e); err != nil {
+		return Endpoints{}, fmt.Errorf("failed to parse discovery response: %w", err)
+	}
+
+	// The spec requires this, and tokens are checked against our issuer
+	if e.Issuer != issuer {
+		return Endpoints{}, fmt.Errorf("discovery document is for issuer %q, expected %q", e.Issuer, issuer)
+	}
+
+	discoveryCache.endpoints[issuer] = e
+	return e, nil
+}
@@ -0,0 +1,248 @@
+package cognitoauth
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"echo-cognito-auth/sessionstore"
+)
+
+// LoginError is the internal error of the HTTP errors returned when a login
+// can't be completed, so an error handler can offer the user a link to try
+// logging in again.
+type LoginError struct {
+	RetryURL string
+	Err      error
+}
+
+func (e *LoginError) Error() string {
+	return "login failed: " + e.Err.Error()
+}
+
+func (e *LoginError) Unwrap() error {
+	return e.Err
+}
+
+// maxLoggedDescriptionLength limits how much of an error_description from the
+// callback URL we log, as anyone can put anything in it.
+const maxLoggedDescriptionLength = 200
+
+// loginFailed returns a 400 error with a message for the user.
+func (a *Auth) loginFailed(message string, err error) error {
+	return echo.NewHTTPError(http.StatusBadRequest, message).
+		SetInternal(&LoginError{RetryURL: a.loginPath, Err: err})
+}
+
+// callbackError returns the OAuth error Cognito redirected back with instead of
+// an authorization code, e.g. ?error=access_denied&error_description=..., or
+// nil if there isn't one.
+func callbackError(c echo.Context) *OAuthError {
+	code := c.QueryParam("error")
+	if code == "" {
+		return nil
+	}
+
+	return &OAuthError{Code: code, Description: c.QueryParam("error_description")}
+}
+
+// callbackErrorMessage is the message shown to the user for an OAuth error from
+// the login redirect. The error_description isn't shown, as it isn't meant for
+// users and anyone can craft a link with any text in it.
+func callbackErrorMessage(err *OAuthError) string {
+	switch {
+	case errors.Is(err, ErrAccessDenied):
+		return "The login was cancelled, or you don't have access to this app."
+	case errors.Is(err, ErrServerError), errors.Is(err, ErrTemporarilyUnavailable):
+		return "Cognito is having problems right now, please try again in a moment."
+	default:
+		return "We could not complete your login, please try again."
+	}
+}
+
+// truncate shortens s to at most n bytes, for logging untrusted values.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// restartLogin starts a new login attempt in place of the failed one, keeping
+// where the user was going, and sends them back to the Cognito managed login.
+// If they still have a Cognito session that's invisible to them.
+func (a *Auth) restartLogin(c echo.Context, failed *loginAttempt) error {
+	attempt, err := newLoginAttempt(failed.ReturnTo)
+	if err != nil {
+		return err
+	}
+	attempt.Restarted = true
+
+	if err := a.saveLoginAttempt(c, attempt); err != nil {
+		return err
+	}
+
+	return c.Redirect(http.StatusTemporaryRedirect, a.hostedLoginURL(attempt))
+}
+
+// LoginHandler sends the user to the Cognito managed login page, unless they
+// are already logged in.
+func (a *Auth) LoginHandler(c echo.Context) error {
+	returnTo := safeReturnPath(c.QueryParam(ReturnToParam))
+
+	// Check if user is already authenticated via session
+	if UserFromContext(c) != nil {
+		// User is already logged in, redirect to where they were going
+		if returnTo == "" {
+			returnTo = "/"
+		}
+		return c.Redirect(http.StatusTemporaryRedirect, returnTo)
+	}
+
+	attempt, err := newLoginAttempt(returnTo)
+	if err != nil {
+		a.logger.Error("LoginHandler: failed to create login attempt", "error", err)
+		return err
+	}
+
+	if err := a.saveLoginAttempt(c, attempt); err != nil {
+		a.logger.Error("LoginHandler: failed to save login attempt", "error", err)
+		return err
+	}
+
+	return c.Redirect(http.StatusTemporaryRedirect, a.hostedLoginURL(attempt))
+}
+
+// CallbackHandler completes the login when Cognito redirects back to us with
+// an authorization code.
+func (a *Auth) CallbackHandler(c echo.Context) error {
+	attempt, err := a.getLoginAttempt(c)
+	if err != nil {
+		a.logger.Error("CallbackHandler: failed to get login attempt", "error", err)
+		return err
+	}
+
+	// Check the state before anything else, so a callback that isn't for the
+	// login in progress (e.g. a link from another site) can't end it
+	if err := attempt.verifyState(c.QueryParam("state")); err != nil {
+		if oauthErr := callbackError(c); oauthErr != nil {
+			// Only an error for the login in progress is from Cognito
+			a.logger.Warn("CallbackHandler: rejected login error without a matching state",
+				"error", oauthErr.Code,
+				"stateError", err)
+			err = errStateNotFound
+		} else {
+			a.logger.Warn("CallbackHandler: rejected login state", "error", err)
+		}
+		return a.loginFailed("We could not complete your login: "+err.Error()+".", err)
+	}
+
+	// The login attempt is single use, so it is removed now the state matched
+	if err := a.removeLoginAttempt(c); err != nil {
+		a.logger.Error("CallbackHandler: failed to remove login attempt", "error", err)
+		return err
+	}
+
+	// Don't log the query, it has the authorization code in it
+	if oauthErr := callbackError(c); oauthErr != nil {
+		a.logger.Warn("CallbackHandler: login was not completed",
+			"error", oauthErr.Code,
+			"description", truncate(oauthErr.Description, maxLoggedDescriptionLength))
+		return a.loginFailed(callbackErrorMessage(oauthErr), oauthErr)
+	}
+
+	code := c.QueryParam("code")
+	if code == "" {
+		a.logger.Error("CallbackHandler: no code in request")
+		return a.loginFailed("No authorization code provided", errors.New("no code in request"))
+	}
+
+	ctx := c.Request().Context()
+
+	// Exchange the authorization code for tokens
+	tokenResponse, err := a.exchangeCodeForTokens(ctx, code, attempt.CodeVerifier)
+	if errors.Is(err, ErrInvalidGrant) {
+		// The code was already used (e.g. the user refreshed the callback page, or
+		// went back to it) or has expired. Start over once, which is seamless if
+		// they're still logged in at Cognito.
+		if !attempt.Restarted {
+			a.logger.Info("CallbackHandler: authorization code rejected, restarting login", "error", err)
+			return a.restartLogin(c, attempt)
+		}
+		a.logger.Warn("CallbackHandler: authorization code rejected again after restarting login", "error", err)
+		return a.loginFailed("We could not complete your login, please try again.", err)
+	}
+	if err != nil {
+		a.logger.Error("CallbackHandler: failed to exchange code for tokens", "error", err)
+		return err
+	}
+
+	// Get the user from the verified ID token claims
+	claims, err := a.verifier.verifyIDToken(ctx, tokenResponse.IDToken, attempt.Nonce)
+	if errors.Is(err, errNonceMismatch) {
+		// Someone is trying to replay an ID token from another login
+		a.logger.Warn("CallbackHandler: security event: ID token nonce mismatch",
+			"event", "id_token_nonce_mismatch",
+			"ip", c.RealIP(),
+			"userAgent", c.Request().UserAgent())
+		return a.loginFailed("We could not complete your login: the response from Cognito does not belong to the login started in this browser.", err)
+	}
+	if err != nil {
+		a.logger.Error("CallbackHandler: failed to verify ID token", "error", err)
+		return err
+	}
+
+	user := userFromClaims(claims)
+
+	if a.cfg.OnLogin != nil {
+		if err := a.cfg.OnLogin(c, &user, tokenResponse); err != nil {
+			a.logger.Error("CallbackHandler: OnLogin failed", "userID", user.ID, "error", err)
+			return err
+		}
+	}
+
+	// Store user in session
+	sess, err := a.getSession(c)
+	if err != nil {
+		a.logger.Error("CallbackHandler: failed to get session", "error", err)
+		return err
+	}
+
+	// Give the logged in session a new ID, so one that was planted before (or
+	// seen while) logged out can't be used to take it over
+	if err := a.regenerateSession(c, sess); err != nil {
+		a.logger.Error("CallbackHandler: failed to regenerate session", "error", err)
+		return err
+	}
+
+	sess.Values[sessionUserKey] = user
+	sess.Values[sessionTokensKey] = newSessionTokens(tokenResponse, "")
+	info := newSessionInfo(c, sess, user.ID)
+	info.ProviderSessionID = claims.SessionID
+	sess.Values[sessionstore.InfoKey] = info
+
+	if err := sess.Save(c.Request(), c.Response()); err != nil {
+		a.logger.Error("CallbackHandler: failed to save session", "error", err)
+		return err
+	}
+
+	a.logger.Info("CallbackHandler: completed user auth", "user", user)
+	return c.Redirect(http.StatusTemporaryRedirect, attempt.redirectPath())
+}
+
+// LogoutHandler ends the user's session, revokes their tokens and sends them
+// to the Cognito logout page, which returns them to the app's home page.
+func (a *Auth) LogoutHandler(c echo.Context) error {
+	if user := UserFromContext(c); user != nil && a.cfg.OnLogout != nil {
+		a.cfg.OnLogout(c, user)
+	}
+
+	a.logoutAndRevoke(c)
+	logoutURL := a.hostedLogoutURL(c.Request().Host)
+	if logoutURL == "" {
+		// The provider has no logout page, so just go to the home page
+		logoutURL = "/"
+	}
+	return c.Redirect(http.StatusTemporaryRedirect, logoutURL)
+}
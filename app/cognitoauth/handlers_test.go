@@ -0,0 +1,271 @@
+package cognitoauth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"echo-cognito-auth/apptest"
+	"echo-cognito-auth/cognitoauth"
+	"echo-cognito-auth/config"
+	"echo-cognito-auth/mockcognito"
+	"echo-cognito-auth/models"
+)
+
+// callbackPath returns the callback path with the query.
+func callbackPath(query url.Values) string {
+	return cognitoauth.DefaultCallbackPath + "?" + query.Encode()
+}
+
+func TestCallbackErrorNeedsMatchingState(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{"wrong state", url.Values{"error": {"access_denied"}, "state": {"forged"}}},
+		{"no state", url.Values{"error": {"access_denied"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			app := apptest.New(t, apptest.Options{})
+			attempt, authorizeURL := app.StartLogin(t, nil)
+
+			// A link from another site doesn't end the login in progress
+			rec := app.Get(callbackPath(tt.query), attempt)
+			apptest.AssertStatus(t, rec, http.StatusBadRequest)
+			if tt.query.Has("state") {
+				apptest.AssertContains(t, rec, "there is no login in progress")
+			}
+
+			callbackURL := app.CognitoLogin(t, authorizeURL, models.User{ID: "alice-id", Name: "alice"})
+			rec = app.Get(callbackURL.RequestURI(), attempt)
+			apptest.AssertRedirect(t, rec, "/")
+			if app.Cookie(rec) == nil {
+				t.Error("the login didn't set the session cookie")
+			}
+		})
+	}
+}
+
+func TestCallbackErrorWithMatchingState(t *testing.T) {
+	t.Parallel()
+
+	app := apptest.New(t, apptest.Options{})
+	attempt, authorizeURL := app.StartLogin(t, nil)
+	query := url.Values{
+		"error":             {"access_denied"},
+		"error_description": {"User cancelled"},
+		"state":             {authorizeURL.Query().Get("state")},
+	}
+
+	rec := app.Get(callbackPath(query), attempt)
+	apptest.AssertStatus(t, rec, http.StatusBadRequest)
+	apptest.AssertContains(t, rec, "The login was cancelled")
+
+	// The login attempt was used up
+	rec = app.Get(callbackPath(query), attempt)
+	apptest.AssertStatus(t, rec, http.StatusBadRequest)
+	apptest.AssertContains(t, rec, "there is no login in progress")
+}
+
+func TestLoginAfterKeyRotation(t *testing.T) {
+	t.Parallel()
+
+	for _, store := range []string{config.SessionStoreMemory, config.SessionStoreBolt} {
+		t.Run(store, func(t *testing.T) {
+			t.Parallel()
+
+			configure := func(cfg *config.Config) {
+				cfg.Session.Store = store
+				cfg.Session.BoltPath = filepath.Join(t.TempDir(), "sessions.db")
+			}
+			alice := models.User{ID: "alice-id", Name: "alice"}
+
+			// A cookie from before the keys were rotated. The apps have their
+			// own random keys.
+			stale := apptest.New(t, apptest.Options{Configure: configure}).SessionCookie(t, alice)
+			app := apptest.New(t, apptest.Options{Configure: configure})
+
+			apptest.AssertStatus(t, app.Get("/", stale), http.StatusOK)
+			apptest.AssertRedirect(t, app.Get("/user", stale), cognitoauth.DefaultLoginPath)
+
+			// A callback with only the stale cookie has no login in progress
+			rec := app.Get(callbackPath(url.Values{"code": {"code"}, "state": {"state"}}), stale)
+			apptest.AssertStatus(t, rec, http.StatusBadRequest)
+			apptest.AssertContains(t, rec, "there is no login in progress")
+
+			attempt, authorizeURL := app.StartLogin(t, stale)
+			callbackURL := app.CognitoLogin(t, authorizeURL, alice)
+			rec = app.Get(callbackURL.RequestURI(), attempt)
+			apptest.AssertRedirect(t, rec, "/")
+
+			session := app.Cookie(rec)
+			if session == nil {
+				t.Fatal("the login didn't set the session cookie")
+			}
+			apptest.AssertStatus(t, app.Get("/user", session), http.StatusOK)
+		})
+	}
+}
+
+func TestCallbackState(t *testing.T) {
+	t.Parallel()
+
+	alice := models.User{ID: "alice-id", Name: "alice"}
+
+	t.Run("reused", func(t *testing.T) {
+		t.Parallel()
+
+		app := apptest.New(t, apptest.Options{})
+		attempt, authorizeURL := app.StartLogin(t, nil)
+		callbackURL := app.CognitoLogin(t, authorizeURL, alice)
+		apptest.AssertRedirect(t, app.Get(callbackURL.RequestURI(), attempt), "/")
+
+		rec := app.Get(callbackURL.RequestURI(), attempt)
+		apptest.AssertStatus(t, rec, http.StatusBadRequest)
+		apptest.AssertContains(t, rec, "there is no login in progress")
+	})
+
+	t.Run("wrong state", func(t *testing.T) {
+		t.Parallel()
+
+		app := apptest.New(t, apptest.Options{})
+		attempt, authorizeURL := app.StartLogin(t, nil)
+		callbackURL := app.CognitoLogin(t, authorizeURL, alice)
+
+		query := callbackURL.Query()
+		query.Set("state", "forged")
+		rec := app.Get(callbackPath(query), attempt)
+		apptest.AssertStatus(t, rec, http.StatusBadRequest)
+		apptest.AssertContains(t, rec, "does not belong to the login started in this browser")
+
+		// The real callback still works
+		apptest.AssertRedirect(t, app.Get(callbackURL.RequestURI(), attempt), "/")
+	})
+
+	t.Run("no session", func(t *testing.T) {
+		t.Parallel()
+
+		app := apptest.New(t, apptest.Options{})
+		_, authorizeURL := app.StartLogin(t, nil)
+		callbackURL := app.CognitoLogin(t, authorizeURL, alice)
+
+		rec := app.Get(callbackURL.RequestURI(), nil)
+		apptest.AssertStatus(t, rec, http.StatusBadRequest)
+		apptest.AssertContains(t, rec, "there is no login in progress")
+	})
+}
+
+func TestCallbackPKCE(t *testing.T) {
+	t.Parallel()
+
+	app := apptest.New(t, apptest.Options{})
+	alice := models.User{ID: "alice-id", Name: "alice"}
+
+	_, authorizeURL := app.StartLogin(t, nil)
+	if q := authorizeURL.Query(); q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorize URL %s doesn't have an S256 code_challenge", authorizeURL)
+	}
+
+	// A code stolen from another login, put in the callback of the victim's
+	// login (with its state), can't be redeemed without its code_verifier. The
+	// login is restarted instead.
+	stolen := app.CognitoLogin(t, authorizeURL, alice)
+	victim, victimAuthorizeURL := app.StartLogin(t, nil)
+	query := url.Values{
+		"code":  {stolen.Query().Get("code")},
+		"state": {victimAuthorizeURL.Query().Get("state")},
+	}
+
+	rec := app.Get(callbackPath(query), victim)
+	if app.Cookie(rec) != nil && rec.Header().Get("Location") == "/" {
+		t.Fatal("the login completed with a code from another login")
+	}
+	apptest.AssertRedirect(t, rec, app.Cognito.URL+mockcognito.PathAuthorize)
+}
+
+func TestLoginWithCognitoSizedTokens(t *testing.T) {
+	t.Parallel()
+
+	app := apptest.New(t, apptest.Options{Cognito: mockcognito.Options{RefreshTokenSize: 1800}})
+	alice := models.User{ID: "alice-id", Name: "Alice Example", Groups: []string{"admin", "editors", "support"}}
+
+	attempt, authorizeURL := app.StartLogin(t, nil)
+	callbackURL := app.CognitoLogin(t, authorizeURL, alice)
+	req := httptest.NewRequest(http.MethodGet, app.Config.AppURL+callbackURL.RequestURI(), nil)
+	req.AddCookie(attempt)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36")
+	rec := app.Serve(req)
+	apptest.AssertRedirect(t, rec, "/")
+
+	// The tokens are kept on the server, the cookie only has the session ID
+	session := app.Cookie(rec)
+	if session == nil {
+		t.Fatal("the login didn't set the session cookie")
+	}
+	if len(session.Value) > 512 {
+		t.Errorf("session cookie is %d bytes, want just the session ID", len(session.Value))
+	}
+	apptest.AssertStatus(t, app.Get("/user", session), http.StatusOK)
+}
+
+func TestLogoutWithSlowCognito(t *testing.T) {
+	t.Parallel()
+
+	app := apptest.New(t, apptest.Options{})
+	session := app.SessionCookie(t, models.User{ID: "alice-id", Name: "alice"})
+	app.Cognito.FailNext(mockcognito.PathRevoke, mockcognito.Failure{Status: http.StatusServiceUnavailable, Delay: time.Minute})
+
+	start := time.Now()
+	rec := app.Get(cognitoauth.DefaultLogoutPath, session)
+	apptest.AssertRedirect(t, rec, app.Cognito.URL+mockcognito.PathLogout)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("logging out took %s, want it not to wait for Cognito", elapsed)
+	}
+
+	apptest.AssertRedirect(t, app.Get("/user", session), cognitoauth.DefaultLoginPath)
+}
+
+func TestFrontChannelLogout(t *testing.T) {
+	t.Parallel()
+
+	app := apptest.New(t, apptest.Options{})
+	alice := models.User{ID: "alice-id", Name: "alice"}
+	session := app.SessionCookie(t, alice)
+	sids := app.Cognito.SessionIDs(alice.Name)
+	if len(sids) != 1 {
+		t.Fatalf("alice has %d Cognito sessions, want 1", len(sids))
+	}
+	issuer := app.Cognito.IssuerURL()
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  int
+	}{
+		{"no iss or sid", url.Values{}, http.StatusBadRequest},
+		{"no sid", url.Values{"iss": {issuer}}, http.StatusBadRequest},
+		{"no iss", url.Values{"sid": {sids[0]}}, http.StatusBadRequest},
+		{"wrong iss", url.Values{"iss": {"https://evil.example.com"}, "sid": {sids[0]}}, http.StatusBadRequest},
+		{"other login", url.Values{"iss": {issuer}, "sid": {"other-sid"}}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := app.Get(cognitoauth.DefaultFrontChannelLogoutPath+"?"+tt.query.Encode(), session)
+		apptest.AssertStatus(t, rec, tt.want)
+		if app.Cookie(rec) != nil {
+			t.Errorf("%s: logged the user out", tt.name)
+		}
+	}
+	apptest.AssertStatus(t, app.Get("/user", session), http.StatusOK)
+
+	query := url.Values{"iss": {issuer}, "sid": {sids[0]}}
+	apptest.AssertStatus(t, app.Get(cognitoauth.DefaultFrontChannelLogoutPath+"?"+query.Encode(), session), http.StatusOK)
+	apptest.AssertRedirect(t, app.Get("/user", session), cognitoauth.DefaultLoginPath)
+}
@@ -0,0 +1,308 @@
+package cognitoauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// jwksMinRefreshInterval limits how often an unknown key ID can make us
+	// re-fetch the JWKS, so garbage tokens can't be used to hammer Cognito.
+	jwksMinRefreshInterval = time.Minute
+
+	// tokenLeeway allows for a little clock skew between us and Cognito.
+	tokenLeeway = 30 * time.Second
+)
+
+var (
+	errUnknownKeyID  = errors.New("token is signed with an unknown key")
+	errWrongTokenUse = errors.New("token has the wrong token_use")
+	errNonceMismatch = errors.New("token nonce does not match the login attempt")
+)
+
+// IDTokenClaims are the claims we use from a Cognito ID token. See:
+// https://docs.aws.amazon.com/cognito/latest/developerguide/amazon-cognito-user-pools-using-the-id-token.html
+type IDTokenClaims struct {
+	TokenUse      string   `json:"token_use"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+	Username      string   `json:"cognito:username"`
+	Groups        []string `json:"cognito:groups"`
+	SessionID     string   `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// jsonWebKey is a single RSA key from the user pool's JWKS.
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// jwtVerifier verifies tokens signed by a Cognito user pool. The pool's public
+// keys are fetched on first use and cached, and fetched again when a token
+// shows up with a key ID we don't know (i.e. Cognito rotated its keys).
+type jwtVerifier struct {
+	issuer   string
+	jwksURL  string
+	clientID string
+	client   *Client
+	logger   *slog.Logger
+
+	// fetches makes concurrent refreshes share one fetch.
+	fetches singleflight.Group
+
+	mu   sync.RWMutex
+	keys map[string]*rsa.PublicKey
+	// fetchedAt is when the JWKS was last fetched, or tried to be, and
+	// fetchErr is why that failed, if it did.
+	fetchedAt time.Time
+	fetchErr  error
+}
+
+func newJWTVerifier(issuer, jwksURL, clientID string, client *Client, logger *slog.Logger) *jwtVerifier {
+	return &jwtVerifier{
+		issuer:   issuer,
+		jwksURL:  jwksURL,
+		clientID: clientID,
+		client:   client,
+		logger:   logger,
+	}
+}
+
+// verifyIDToken checks the ID token's signature, issuer, audience, token_use,
+// expiry and issued at time. If nonce is not empty, the token's nonce claim
+// must match it (it's empty only for tokens that didn't come from a login
+// redirect, e.g. a refresh).
+func (v *jwtVerifier) verifyIDToken(ctx context.Context, idToken, nonce string) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, v.keyFunc(ctx),
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(v.issuer),
+		jwt.WithAudience(v.clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(tokenLeeway),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	if claims.TokenUse != "id" {
+		return nil, fmt.Errorf("invalid ID token: %w: %q", errWrongTokenUse, claims.TokenUse)
+	}
+
+	if nonce != "" && subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("invalid ID token: %w", errNonceMismatch)
+	}
+
+	return claims, nil
+}
+
+// backChannelLogoutEvent is the events claim member that makes a JWT a logout
+// token. See https://openid.net/specs/openid-connect-backchannel-1_0.html
+const backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// LogoutTokenClaims are the claims of an OIDC back-channel logout token. It
+// identifies the user (sub), their login session at the provider (sid), or
+// both.
+type LogoutTokenClaims struct {
+	SessionID string                     `json:"sid"`
+	Events    map[string]json.RawMessage `json:"events"`
+	Nonce     string                     `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// verifyLogoutToken checks the logout token's signature, type, issuer,
+// audience, expiry and issued at time, and that it has the claims a logout
+// token must (and no nonce, so an ID token can't be passed off as one).
+func (v *jwtVerifier) verifyLogoutToken(ctx context.Context, logoutToken string) (*LogoutTokenClaims, error) {
+	claims := &LogoutTokenClaims{}
+	token, err := jwt.ParseWithClaims(logoutToken, claims, v.keyFunc(ctx),
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(v.issuer),
+		jwt.WithAudience(v.clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(tokenLeeway),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid logout token: %w", err)
+	}
+
+	if typ, ok := token.Header["typ"].(string); ok && typ != "logout+jwt" && typ != "JWT" {
+		return nil, fmt.Errorf("invalid logout token: wrong typ %q", typ)
+	}
+	if _, ok := claims.Events[backChannelLogoutEvent]; !ok {
+		return nil, errors.New("invalid logout token: no logout event")
+	}
+	if claims.Subject == "" && claims.SessionID == "" {
+		return nil, errors.New("invalid logout token: no sub or sid")
+	}
+	if claims.ID == "" {
+		return nil, errors.New("invalid logout token: no jti")
+	}
+	if claims.Nonce != "" {
+		return nil, errors.New("invalid logout token: has a nonce")
+	}
+
+	return claims, nil
+}
+
+// keyFunc returns a jwt.Keyfunc that returns the public key for the token's
+// key ID, refreshing the cached keys once (within ctx) if the ID isn't known.
+func (v *jwtVerifier) keyFunc(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no kid header")
+		}
+
+		if key := v.cachedKey(kid); key != nil {
+			return key, nil
+		}
+
+		if err := v.refreshKeys(ctx); err != nil {
+			return nil, err
+		}
+
+		if key := v.cachedKey(kid); key != nil {
+			return key, nil
+		}
+
+		return nil, fmt.Errorf("%w: %s", errUnknownKeyID, kid)
+	}
+}
+
+func (v *jwtVerifier) cachedKey(kid string) *rsa.PublicKey {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return v.keys[kid]
+}
+
+// refreshKeys fetches the JWKS, unless it was fetched (or tried to be) very
+// recently, in which case it returns the error from then, if any. Concurrent
+// calls share one fetch, and the lock isn't held while fetching, so tokens can
+// still be verified with the keys already loaded.
+func (v *jwtVerifier) refreshKeys(ctx context.Context) error {
+	v.mu.RLock()
+	fetchedAt, fetchErr := v.fetchedAt, v.fetchErr
+	v.mu.RUnlock()
+	if time.Since(fetchedAt) < jwksMinRefreshInterval {
+		return fetchErr
+	}
+
+	fetch := v.fetches.DoChan("jwks", func() (any, error) {
+		// The fetch is shared, so it mustn't end with the request that started it
+		keys, err := v.fetchKeys(context.WithoutCancel(ctx))
+		if err == nil {
+			v.logger.Info("refreshKeys: loaded user pool JWKS", "url", v.jwksURL, "keys", keys)
+		}
+		return nil, err
+	})
+
+	select {
+	case result := <-fetch:
+		return result.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkKeys fetches the JWKS, to check it can be, and keeps the keys.
+func (v *jwtVerifier) checkKeys(ctx context.Context) error {
+	_, err := v.fetchKeys(ctx)
+	return err
+}
+
+// fetchKeys fetches the JWKS and keeps the keys, returning how many there are.
+// The attempt is recorded even if it fails, so failures are rate limited too,
+// unless it was cancelled.
+func (v *jwtVerifier) fetchKeys(ctx context.Context) (int, error) {
+	keys, err := v.fetchJWKS(ctx)
+	if err != nil && ctx.Err() != nil {
+		return 0, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.fetchedAt = time.Now()
+	v.fetchErr = err
+	if err != nil {
+		return 0, err
+	}
+	v.keys = keys
+
+	return len(keys), nil
+}
+
+// fetchJWKS downloads the JWKS and returns its RSA signing keys by key ID.
+func (v *jwtVerifier) fetchJWKS(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	body, err := v.client.get(ctx, v.jwksURL)
+	if err != nil {
+		return nil, fmt.Errorf("JWKS request failed: %w", err)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS response: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+
+		key, err := jwk.rsaPublicKey()
+		if err != nil {
+			v.logger.Error("fetchJWKS: skipping invalid key", "kid", jwk.Kid, "error", err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("exponent too large")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
@@ -0,0 +1,486 @@
+package cognitoauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testIssuer   = "https://cognito-idp.eu-west-2.amazonaws.com/eu-west-2_test"
+	testClientID = "test-client"
+)
+
+// testJWKS serves a JWKS with the keys, counting the requests for it. If status
+// is set, it fails with it instead, and if hold is set, requests wait for it to
+// be closed.
+type testJWKS struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	keys     map[string]*rsa.PrivateKey
+	requests int
+	status   int
+	hold     chan struct{}
+}
+
+func newTestJWKS(t *testing.T, kids ...string) *testJWKS {
+	j := &testJWKS{keys: map[string]*rsa.PrivateKey{}}
+	for _, kid := range kids {
+		j.addKey(t, kid)
+	}
+
+	j.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j.mu.Lock()
+		j.requests++
+		status, hold := j.status, j.hold
+		j.mu.Unlock()
+
+		if hold != nil {
+			<-hold
+		}
+		if status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+
+		var jwks struct {
+			Keys []jsonWebKey `json:"keys"`
+		}
+		for kid, key := range j.keys {
+			jwks.Keys = append(jwks.Keys, jsonWebKey{
+				Kid: kid,
+				Kty: "RSA",
+				Alg: "RS256",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(j.Close)
+
+	return j
+}
+
+func (j *testJWKS) addKey(t *testing.T, kid string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.keys[kid] = key
+	return key
+}
+
+func (j *testJWKS) key(kid string) *rsa.PrivateKey {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.keys[kid]
+}
+
+func (j *testJWKS) requestCount() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.requests
+}
+
+// set sets the status and hold, see testJWKS.
+func (j *testJWKS) set(status int, hold chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status = status
+	j.hold = hold
+}
+
+// waitForRequests waits for the JWKS to have been requested n times.
+func (j *testJWKS) waitForRequests(t *testing.T, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); j.requestCount() < n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("JWKS fetched %d times, want %d", j.requestCount(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// expireKeys makes the keys old enough to be refreshed.
+func (v *jwtVerifier) expireKeys() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.fetchedAt = time.Now().Add(-jwksMinRefreshInterval)
+}
+
+func (j *testJWKS) verifier() *jwtVerifier {
+	client := NewClient(ClientOptions{MaxRetries: -1})
+	return newJWTVerifier(testIssuer, j.URL, testClientID, client, slog.New(slog.DiscardHandler))
+}
+
+// sign signs the claims with the key, with the kid header.
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.Claims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validIDTokenClaims() *IDTokenClaims {
+	now := time.Now()
+	return &IDTokenClaims{
+		TokenUse: "id",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    testIssuer,
+			Subject:   "user-id",
+			Audience:  jwt.ClaimStrings{testClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	jwks := newTestJWKS(t, "key-1")
+	v := jwks.verifier()
+	key := jwks.key("key-1")
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		change  func(*IDTokenClaims)
+		key     *rsa.PrivateKey
+		wantErr error
+	}{
+		{name: "valid"},
+		{name: "wrong aud", change: func(c *IDTokenClaims) { c.Audience = jwt.ClaimStrings{"other-client"} }, wantErr: jwt.ErrTokenInvalidAudience},
+		{name: "no aud", change: func(c *IDTokenClaims) { c.Audience = nil }, wantErr: jwt.ErrTokenRequiredClaimMissing},
+		{name: "wrong iss", change: func(c *IDTokenClaims) { c.Issuer = "https://evil.example.com" }, wantErr: jwt.ErrTokenInvalidIssuer},
+		{name: "access token", change: func(c *IDTokenClaims) { c.TokenUse = "access" }, wantErr: errWrongTokenUse},
+		{name: "no token_use", change: func(c *IDTokenClaims) { c.TokenUse = "" }, wantErr: errWrongTokenUse},
+		{name: "expired", change: func(c *IDTokenClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+		}, wantErr: jwt.ErrTokenExpired},
+		{name: "no exp", change: func(c *IDTokenClaims) { c.ExpiresAt = nil }, wantErr: jwt.ErrTokenRequiredClaimMissing},
+		{name: "issued in the future", change: func(c *IDTokenClaims) {
+			c.IssuedAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+		}, wantErr: jwt.ErrTokenUsedBeforeIssued},
+		{name: "wrong key", key: otherKey, wantErr: jwt.ErrTokenSignatureInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validIDTokenClaims()
+			if tt.change != nil {
+				tt.change(claims)
+			}
+			signingKey := key
+			if tt.key != nil {
+				signingKey = tt.key
+			}
+
+			_, err := v.verifyIDToken(context.Background(), sign(t, signingKey, "key-1", claims), "")
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("verifyIDToken() error = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("verifyIDToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenNonce(t *testing.T) {
+	jwks := newTestJWKS(t, "key-1")
+	v := jwks.verifier()
+
+	tests := []struct {
+		name       string
+		tokenNonce string
+		nonce      string
+		wantErr    error
+	}{
+		{"matching", "nonce", "nonce", nil},
+		{"no nonce expected", "nonce", "", nil},
+		{"mismatch", "nonce", "other", errNonceMismatch},
+		{"prefix of the nonce", "nonce", "nonc", errNonceMismatch},
+		{"no nonce in the token", "", "nonce", errNonceMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validIDTokenClaims()
+			claims.Nonce = tt.tokenNonce
+
+			_, err := v.verifyIDToken(context.Background(), sign(t, jwks.key("key-1"), "key-1", claims), tt.nonce)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("verifyIDToken(nonce %q) error = %v, want %v", tt.nonce, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejectsOtherAlgorithms(t *testing.T) {
+	jwks := newTestJWKS(t, "key-1")
+	v := jwks.verifier()
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		key    any
+	}{
+		{"none", jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType},
+		{"HS256 with the public key", jwt.SigningMethodHS256, jwks.key("key-1").PublicKey.N.Bytes()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := jwt.NewWithClaims(tt.method, validIDTokenClaims())
+			token.Header["kid"] = "key-1"
+			signed, err := token.SignedString(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := v.verifyIDToken(context.Background(), signed, ""); !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+				t.Errorf("verifyIDToken() error = %v, want %v", err, jwt.ErrTokenSignatureInvalid)
+			}
+		})
+	}
+}
+
+func TestVerifyAccessToken(t *testing.T) {
+	jwks := newTestJWKS(t, "key-1")
+	v := jwks.verifier()
+	key := jwks.key("key-1")
+
+	valid := func() *AccessTokenClaims {
+		now := time.Now()
+		return &AccessTokenClaims{
+			TokenUse: "access",
+			ClientID: testClientID,
+			Scope:    "openid https://api.example.com/read",
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    testIssuer,
+				Subject:   "user-id",
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		change  func(*AccessTokenClaims)
+		scopes  []string
+		wantErr error
+	}{
+		{name: "valid", scopes: []string{"https://api.example.com/read"}},
+		{name: "ID token", change: func(c *AccessTokenClaims) { c.TokenUse = "id" }, wantErr: errWrongTokenUse},
+		{name: "other client", change: func(c *AccessTokenClaims) { c.ClientID = "other-client" }, wantErr: errClientNotAllowed},
+		{name: "wrong iss", change: func(c *AccessTokenClaims) { c.Issuer = "https://evil.example.com" }, wantErr: jwt.ErrTokenInvalidIssuer},
+		{name: "missing scope", scopes: []string{"https://api.example.com/write"}, wantErr: errInsufficientScope},
+		{name: "scope prefix", change: func(c *AccessTokenClaims) { c.Scope = "https://api.example.com/read-only" }, scopes: []string{"https://api.example.com/read"}, wantErr: errInsufficientScope},
+		{name: "expired", change: func(c *AccessTokenClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+		}, wantErr: jwt.ErrTokenExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			if tt.change != nil {
+				tt.change(claims)
+			}
+
+			_, err := v.verifyAccessToken(context.Background(), sign(t, key, "key-1", claims), []string{testClientID}, tt.scopes)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("verifyAccessToken() error = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("verifyAccessToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnknownKeyIDRefreshesJWKS(t *testing.T) {
+	jwks := newTestJWKS(t, "key-1")
+	v := jwks.verifier()
+	ctx := context.Background()
+
+	if _, err := v.verifyIDToken(ctx, sign(t, jwks.key("key-1"), "key-1", validIDTokenClaims()), ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := jwks.requestCount(); got != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", got)
+	}
+
+	// Cognito rotates its keys
+	newKey := jwks.addKey(t, "key-2")
+	token := sign(t, newKey, "key-2", validIDTokenClaims())
+
+	// Not until the keys are old enough to refresh
+	if _, err := v.verifyIDToken(ctx, token, ""); !errors.Is(err, errUnknownKeyID) {
+		t.Fatalf("verifyIDToken() error = %v, want %v", err, errUnknownKeyID)
+	}
+	if got := jwks.requestCount(); got != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1 as it was just fetched", got)
+	}
+
+	v.expireKeys()
+
+	if _, err := v.verifyIDToken(ctx, token, ""); err != nil {
+		t.Fatalf("verifyIDToken() with the new key error = %v", err)
+	}
+	if got := jwks.requestCount(); got != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", got)
+	}
+
+	// A known key doesn't need a fetch, and a flood of unknown ones only make
+	// one per interval
+	if _, err := v.verifyIDToken(ctx, token, ""); err != nil {
+		t.Fatal(err)
+	}
+	for range 5 {
+		garbage := sign(t, newKey, "unknown", validIDTokenClaims())
+		if _, err := v.verifyIDToken(ctx, garbage, ""); !errors.Is(err, errUnknownKeyID) {
+			t.Fatalf("verifyIDToken() error = %v, want %v", err, errUnknownKeyID)
+		}
+	}
+	if got := jwks.requestCount(); got != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", got)
+	}
+}
+
+func TestJWKSFailuresAreRateLimited(t *testing.T) {
+	jwks := newTestJWKS(t, "key-1")
+	jwks.set(http.StatusServiceUnavailable, nil)
+	v := jwks.verifier()
+	ctx := context.Background()
+	token := sign(t, jwks.key("key-1"), "key-1", validIDTokenClaims())
+
+	// Every token fails with the fetch's error until it can be tried again
+	for range 5 {
+		if _, err := v.verifyIDToken(ctx, token, ""); err == nil || errors.Is(err, errUnknownKeyID) {
+			t.Fatalf("verifyIDToken() error = %v, want the JWKS request's", err)
+		}
+	}
+	if got := jwks.requestCount(); got != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", got)
+	}
+
+	jwks.set(0, nil)
+	v.expireKeys()
+	if _, err := v.verifyIDToken(ctx, token, ""); err != nil {
+		t.Fatalf("verifyIDToken() after the JWKS recovered error = %v", err)
+	}
+	if got := jwks.requestCount(); got != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", got)
+	}
+}
+
+func TestJWKSRefreshDoesNotBlockKnownKeys(t *testing.T) {
+	jwks := newTestJWKS(t, "key-1")
+	v := jwks.verifier()
+	ctx := context.Background()
+	known := sign(t, jwks.key("key-1"), "key-1", validIDTokenClaims())
+	if _, err := v.verifyIDToken(ctx, known, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens with an unknown key wait for a slow refresh, which they share
+	hold := make(chan struct{})
+	jwks.set(0, hold)
+	v.expireKeys()
+	unknown := sign(t, jwks.addKey(t, "key-2"), "key-2", validIDTokenClaims())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for range cap(errs) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := v.verifyIDToken(ctx, unknown, "")
+			errs <- err
+		}()
+	}
+	jwks.waitForRequests(t, 2)
+
+	// Meanwhile, tokens with a known key are verified
+	done := make(chan error)
+	go func() {
+		_, err := v.verifyIDToken(ctx, known, "")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("verifyIDToken() with a known key error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("verifyIDToken() with a known key waited for the refresh")
+	}
+
+	close(hold)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("verifyIDToken() with the new key error = %v", err)
+		}
+	}
+	if got := jwks.requestCount(); got != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", got)
+	}
+}
+
+func TestJWKSRefreshCancelled(t *testing.T) {
+	jwks := newTestJWKS(t, "key-1")
+	hold := make(chan struct{})
+	jwks.set(0, hold)
+	v := jwks.verifier()
+	token := sign(t, jwks.key("key-1"), "key-1", validIDTokenClaims())
+
+	// A request that gives up doesn't end the fetch for everyone else
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		_, err := v.verifyIDToken(ctx, token, "")
+		errs <- err
+	}()
+	jwks.waitForRequests(t, 1)
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("verifyIDToken() error = %v, want %v", err, context.Canceled)
+	}
+
+	close(hold)
+	if _, err := v.verifyIDToken(context.Background(), token, ""); err != nil {
+		t.Errorf("verifyIDToken() error = %v", err)
+	}
+	if got := jwks.requestCount(); got != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", got)
+	}
+}
@@ -0,0 +1,184 @@
+package cognitoauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// loginAttemptTTL is how long a user has to complete the Cognito managed login
+// before the state we handed out is no longer accepted.
+const loginAttemptTTL = 10 * time.Minute
+
+var (
+	errStateMissing  = errors.New("the login response from Cognito did not include a state value")
+	errStateNotFound = errors.New("there is no login in progress for this browser, or it was already completed")
+	errStateExpired  = errors.New("the login took too long to complete and has expired")
+	errStateMismatch = errors.New("the login response does not belong to the login started in this browser")
+)
+
+// loginAttempt holds the values generated when we send a user to the Cognito
+// managed login, which we need to check when they come back to the callback.
+// It is stored in the session, and removed as soon as the callback matches its
+// state so it can only be used once.
+type loginAttempt struct {
+	State        string
+	CodeVerifier string // PKCE code_verifier, only ever sent to the token endpoint
+	Nonce        string // OIDC nonce, must come back in the ID token
+	ReturnTo     string // local path to send the user to after login
+	Restarted    bool   // set when the callback restarted the login, so it only does so once
+	CreatedAt    time.Time
+}
+
+// randomToken returns a URL safe random string built from n random bytes.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random token: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// newLoginAttempt creates a login attempt. The returnTo path must already have
+// been checked with safeReturnPath.
+func newLoginAttempt(returnTo string) (*loginAttempt, error) {
+	state, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	// 32 random bytes gives a 43 character verifier, the minimum length
+	// allowed by RFC 7636.
+	verifier, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	return &loginAttempt{
+		State:        state,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ReturnTo:     returnTo,
+		CreatedAt:    time.Now(),
+	}, nil
+}
+
+// codeChallenge returns the PKCE S256 code_challenge for the attempt's verifier.
+func (a *loginAttempt) codeChallenge() string {
+	sum := sha256.Sum256([]byte(a.CodeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// redirectPath returns where to send the user once the login completes.
+func (a *loginAttempt) redirectPath() string {
+	if a.ReturnTo == "" {
+		return "/"
+	}
+	return a.ReturnTo
+}
+
+// safeReturnPath returns the path if it is a same origin relative path (e.g.
+// "/user?tab=1"), or "" if it isn't, so it can't be used as an open redirect.
+func safeReturnPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") {
+		return ""
+	}
+
+	// Browsers treat backslashes as slashes, so "/\evil.com" is "//evil.com"
+	if strings.ContainsAny(path, "\\\r\n\t") {
+		return ""
+	}
+
+	u, err := url.Parse(path)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return ""
+	}
+
+	return u.RequestURI()
+}
+
+// saveLoginAttempt stores the attempt in the session, replacing any earlier one.
+func (a *Auth) saveLoginAttempt(c echo.Context, attempt *loginAttempt) error {
+	sess, err := a.getSession(c)
+	if err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+
+	sess.Values[sessionLoginAttemptKey] = *attempt
+	if err := sess.Save(c.Request(), c.Response()); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+
+	return nil
+}
+
+// getLoginAttempt returns the login attempt in the session, or nil if there
+// isn't one. It's left in the session until removeLoginAttempt, which the
+// callback only calls once the state matches it.
+func (a *Auth) getLoginAttempt(c echo.Context) (*loginAttempt, error) {
+	sess, err := a.getSession(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	value, ok := sess.Values[sessionLoginAttemptKey]
+	if !ok {
+		return nil, nil
+	}
+
+	attempt, ok := value.(loginAttempt)
+	if !ok {
+		a.logger.Error("getLoginAttempt: login attempt is not the proper type", "attempt", value)
+		return nil, nil
+	}
+
+	return &attempt, nil
+}
+
+// removeLoginAttempt removes the login attempt from the session, so it can only
+// be used once.
+func (a *Auth) removeLoginAttempt(c echo.Context) error {
+	sess, err := a.getSession(c)
+	if err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+
+	delete(sess.Values, sessionLoginAttemptKey)
+	if err := sess.Save(c.Request(), c.Response()); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+
+	return nil
+}
+
+// verifyState checks the state returned by Cognito against the login attempt.
+func (a *loginAttempt) verifyState(state string) error {
+	if state == "" {
+		return errStateMissing
+	}
+	if a == nil {
+		return errStateNotFound
+	}
+	if time.Since(a.CreatedAt) > loginAttemptTTL {
+		return errStateExpired
+	}
+	if subtle.ConstantTimeCompare([]byte(a.State), []byte(state)) != 1 {
+		return errStateMismatch
+	}
+
+	return nil
+}
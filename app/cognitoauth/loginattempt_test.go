@@ -0,0 +1,98 @@
+package cognitoauth
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestVerifyState(t *testing.T) {
+	attempt := &loginAttempt{State: "state", CreatedAt: time.Now()}
+	expired := &loginAttempt{State: "state", CreatedAt: time.Now().Add(-loginAttemptTTL - time.Second)}
+
+	tests := []struct {
+		name    string
+		attempt *loginAttempt
+		state   string
+		want    error
+	}{
+		{"matching", attempt, "state", nil},
+		{"missing", attempt, "", errStateMissing},
+		{"no login attempt", nil, "state", errStateNotFound},
+		{"wrong state", attempt, "other", errStateMismatch},
+		{"prefix of the state", attempt, "stat", errStateMismatch},
+		{"expired", expired, "state", errStateExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.attempt.verifyState(tt.state); !errors.Is(err, tt.want) {
+				t.Errorf("verifyState(%q) = %v, want %v", tt.state, err, tt.want)
+			}
+		})
+	}
+}
+
+// pkceVerifier matches a code_verifier allowed by RFC 7636, section 4.1.
+var pkceVerifier = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+func TestNewLoginAttempt(t *testing.T) {
+	a, err := newLoginAttempt("/user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newLoginAttempt("/user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !pkceVerifier.MatchString(a.CodeVerifier) {
+		t.Errorf("CodeVerifier = %q, not a valid PKCE code_verifier", a.CodeVerifier)
+	}
+	if a.State == b.State || a.CodeVerifier == b.CodeVerifier || a.Nonce == b.Nonce {
+		t.Error("two login attempts have the same state, code_verifier or nonce")
+	}
+	if a.State == a.CodeVerifier || a.State == a.Nonce || a.CodeVerifier == a.Nonce {
+		t.Error("a login attempt reuses a value for its state, code_verifier and nonce")
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// BASE64URL(SHA256(verifier)), without padding
+	attempt := &loginAttempt{CodeVerifier: "dBjftJeZ4CVP-mJ92K9qkcDtDVhdgk3mORJLYtddInk"}
+	if got, want := attempt.codeChallenge(), "Z5JP48JWJ-ZM4ngjzD26UtfHJr3SRGgPm24PNDq1GOE"; got != want {
+		t.Errorf("codeChallenge() = %q, want %q", got, want)
+	}
+}
+
+func TestSafeReturnPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/user", "/user"},
+		{"/user?tab=sessions", "/user?tab=sessions"},
+		{"/a/b/../c", "/a/b/../c"},
+		{"/", "/"},
+
+		// Open redirects
+		{"", ""},
+		{"user", ""},
+		{"//evil.com", ""},
+		{"//evil.com/user", ""},
+		{"/\\evil.com", ""},
+		{"\\\\evil.com", ""},
+		{"https://evil.com", ""},
+		{"http://evil.com/user", ""},
+		{"javascript:alert(1)", ""},
+		{"/\tevil.com", ""},
+		{"/\r\nLocation: https://evil.com", ""},
+		{"https:/evil.com", ""},
+		{"///evil.com", ""},
+	}
+	for _, tt := range tests {
+		if got := safeReturnPath(tt.path); got != tt.want {
+			t.Errorf("safeReturnPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package cognitoauth
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"echo-cognito-auth/sessionstore"
+)
+
+// BackChannelLogoutHandler implements OIDC back-channel logout: the provider
+// POSTs a signed logout token when the user signs out of it (e.g. from another
+// app using the same user pool), and we delete all of the user's sessions, or
+// just those for the login session (sid) in the token. It needs
+// sessionstore.Store, and tokens with a sub. See:
+// https://openid.net/specs/openid-connect-backchannel-1_0.html
+func (a *Auth) BackChannelLogoutHandler(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	ctx := c.Request().Context()
+
+	claims, err := a.verifier.verifyLogoutToken(ctx, c.FormValue("logout_token"))
+	if err != nil {
+		a.logger.Warn("BackChannelLogoutHandler: rejected logout token", "error", err)
+		return backChannelLogoutFailed(c, "invalid logout token")
+	}
+
+	if !a.logoutTokens.add(claims.ID, claims.ExpiresAt.Time) {
+		a.logger.Warn("BackChannelLogoutHandler: rejected replayed logout token", "jti", claims.ID)
+		return backChannelLogoutFailed(c, "logout token already used")
+	}
+
+	if claims.Subject == "" {
+		// Sessions are only indexed by user
+		a.logger.Warn("BackChannelLogoutHandler: logout token has no sub", "sid", claims.SessionID)
+		return backChannelLogoutFailed(c, "logout tokens without a sub are not supported")
+	}
+
+	sess, err := a.getSession(c)
+	if err != nil {
+		a.logger.Error("BackChannelLogoutHandler: failed to get session", "error", err)
+		return backChannelLogoutFailed(c, "logout failed")
+	}
+
+	store, ok := sess.Store().(*sessionstore.Store)
+	if !ok {
+		a.logger.Error("BackChannelLogoutHandler: the session store can't delete sessions by user")
+		return backChannelLogoutFailed(c, "logout not supported")
+	}
+
+	// Sessions from before we recorded the provider's session ID might be for
+	// the sid, so they go too
+	var match func(sessionstore.Info) bool
+	if sid := claims.SessionID; sid != "" {
+		match = func(info sessionstore.Info) bool {
+			return info.ProviderSessionID == "" || info.ProviderSessionID == sid
+		}
+	}
+
+	count, err := store.DeleteUserSessionsFunc(ctx, claims.Subject, match)
+	if err != nil {
+		a.logger.Error("BackChannelLogoutHandler: failed to delete sessions", "userID", claims.Subject, "error", err)
+		return backChannelLogoutFailed(c, "logout failed")
+	}
+
+	a.logger.Info("BackChannelLogoutHandler: deleted sessions", "userID", claims.Subject, "sid", claims.SessionID, "count", count)
+	return c.NoContent(http.StatusOK)
+}
+
+// backChannelLogoutFailed responds with the 400 OAuth error the spec requires
+// for any failure.
+func backChannelLogoutFailed(c echo.Context, description string) error {
+	return c.JSON(http.StatusBadRequest, &OAuthError{Code: "invalid_request", Description: description})
+}
+
+// FrontChannelLogoutHandler implements OIDC front-channel logout, for
+// deployments the provider can't reach directly: the provider's logout page
+// loads it in an iframe, and we log out the browser's session. The provider
+// must send the iss and sid (i.e. frontchannel_logout_session_required), and
+// the session is only logged out if it's for that login, so other sites can't
+// use it to log users out.
+//
+// It only works when the provider's domain is on the same site as the app (e.g.
+// a custom Cognito domain). Otherwise the iframe is cross-site, and browsers
+// don't send it the session cookie (it's SameSite=Lax), so there's nothing to
+// log out. See:
+// https://openid.net/specs/openid-connect-frontchannel-1_0.html
+func (a *Auth) FrontChannelLogoutHandler(c echo.Context) error {
+	header := c.Response().Header()
+	header.Set(echo.HeaderCacheControl, "no-cache, no-store")
+	header.Set("Pragma", "no-cache")
+
+	iss, sid := c.QueryParam("iss"), c.QueryParam("sid")
+	if iss != a.endpoints.Issuer || sid == "" {
+		a.logger.Warn("FrontChannelLogoutHandler: rejected logout request", "iss", iss, "sid", sid)
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	user := UserFromContext(c)
+	if user == nil {
+		return c.HTML(http.StatusOK, "")
+	}
+
+	sess, err := a.getSession(c)
+	if err != nil {
+		a.logger.Error("FrontChannelLogoutHandler: failed to get session", "error", err)
+		return err
+	}
+
+	info, _ := sess.Values[sessionstore.InfoKey].(sessionstore.Info)
+	if info.ProviderSessionID != sid {
+		// The user has since logged in again, or the login had no sid
+		return c.HTML(http.StatusOK, "")
+	}
+
+	if a.cfg.OnLogout != nil {
+		a.cfg.OnLogout(c, user)
+	}
+
+	a.logoutAndRevoke(c)
+	a.logger.Info("FrontChannelLogoutHandler: logged out", "userID", user.ID, "sid", sid)
+
+	return c.HTML(http.StatusOK, "")
+}
+
+// seenTokens remembers the IDs (jti) of tokens until they expire, so they can
+// only be used once. It's per process, so it doesn't stop a token being
+// replayed to another Lambda instance, but replaying a logout token can only
+// log the user out again.
+type seenTokens struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+func newSeenTokens() *seenTokens {
+	return &seenTokens{ids: map[string]time.Time{}}
+}
+
+// add records the token ID, and reports whether it's the first time it's been
+// seen.
+func (s *seenTokens) add(id string, expiresAt time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for seen, exp := range s.ids {
+		if now.After(exp.Add(tokenLeeway)) {
+			delete(s.ids, seen)
+		}
+	}
+
+	if _, ok := s.ids[id]; ok {
+		return false
+	}
+	s.ids[id] = expiresAt
+	return true
+}
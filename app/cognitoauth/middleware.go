@@ -0,0 +1,115 @@
+package cognitoauth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"echo-cognito-auth/models"
+)
+
+// CustomContext gives handlers easy access to the logged in user.
+type CustomContext struct {
+	echo.Context
+}
+
+func (c *CustomContext) User() *models.User {
+	return UserFromContext(c)
+}
+
+// UserFromContext returns the logged in user, or nil if there isn't one.
+func UserFromContext(c echo.Context) *models.User {
+	user := c.Get(contextUserKey)
+	if user != nil {
+		return user.(*models.User)
+	}
+	return nil
+}
+
+// SetUser puts the user in the context, as the middleware here does.
+func SetUser(c echo.Context, user *models.User) {
+	c.Set(contextUserKey, user)
+}
+
+// AddUserToContext is a middleware that puts the user from the session (if any)
+// into the context, logging them out if the session has timed out, and
+// refreshing their Cognito tokens when they are close to expiring. It must come
+// after the session middleware.
+func (a *Auth) AddUserToContext(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := a.userFromSession(c)
+		if user != nil {
+			user = a.trackSession(c, user)
+		}
+		if user != nil {
+			user = a.renewSession(c, user)
+		}
+		if user != nil {
+			SetUser(c, user)
+		}
+
+		return next(c)
+	}
+}
+
+// RequireAuth is a middleware that requires a logged in user. Use it after
+// AddUserToContext.
+func (a *Auth) RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user := UserFromContext(c)
+		if user == nil {
+			// Send browsers to login, and back here afterwards. API clients
+			// can't follow a login redirect, so they just get the 401.
+			if !WantsHTML(c) {
+				return echo.NewHTTPError(http.StatusUnauthorized, "You must be logged in to access this page")
+			}
+
+			loginURL := a.loginPath
+			if method := c.Request().Method; method == http.MethodGet || method == http.MethodHead {
+				loginURL += "?" + url.Values{ReturnToParam: {c.Request().URL.RequestURI()}}.Encode()
+			}
+			return c.Redirect(http.StatusSeeOther, loginURL)
+		}
+
+		return next(c)
+	}
+}
+
+// RequireRole is a middleware that only allows users with the role (i.e. in the
+// Cognito group) through. Use it after RequireAuth.
+func (a *Auth) RequireRole(role string) echo.MiddlewareFunc {
+	return a.RequireAnyRole(role)
+}
+
+// RequireAnyRole is a middleware that only allows users with at least one of
+// the roles through. Use it after RequireAuth.
+func (a *Auth) RequireAnyRole(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user := UserFromContext(c)
+			if user == nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "You must be logged in to access this page")
+			}
+
+			if !user.HasAnyRole(roles...) {
+				a.logger.Info("RequireAnyRole: user does not have a required role", "userID", user.ID, "roles", roles)
+				return echo.NewHTTPError(http.StatusForbidden, "You are not authorized to access this page")
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// WantsHTML reports whether the request is from a browser expecting a page, as
+// opposed to an API client or script expecting JSON.
+func WantsHTML(c echo.Context) bool {
+	req := c.Request()
+	if req.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+		return false
+	}
+
+	return strings.Contains(req.Header.Get("Accept"), "text/html")
+}
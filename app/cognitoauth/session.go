@@ -0,0 +1,294 @@
+package cognitoauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+
+	"echo-cognito-auth/models"
+	"echo-cognito-auth/sessionstore"
+)
+
+// tokenRefreshWindow is how long before the access token expires that we
+// refresh it, so a request never runs with a token that is about to expire.
+const tokenRefreshWindow = 5 * time.Minute
+
+// sessionTokens is what we keep from the Cognito tokens in the session: the
+// refresh token to get new ones (which also tells us if the user has been
+// disabled or signed out in Cognito), and when the access token expires. The
+// tokens themselves aren't kept, as they would make cookie sessions too big for
+// a cookie, and a new access token can be got with the refresh token.
+type sessionTokens struct {
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// newSessionTokens builds the session tokens from a token response. A refresh
+// doesn't return a new refresh token (unless rotation is enabled), so the
+// current one is passed in to keep.
+func newSessionTokens(tokens *TokenResponse, currentRefreshToken string) sessionTokens {
+	refreshToken := tokens.RefreshToken
+	if refreshToken == "" {
+		refreshToken = currentRefreshToken
+	}
+
+	return sessionTokens{
+		RefreshToken: refreshToken,
+		ExpiresAt:    time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second),
+	}
+}
+
+// lastSeenUpdateInterval limits how often the session is saved just to update
+// when the user was last seen.
+const lastSeenUpdateInterval = time.Minute
+
+// newSessionInfo describes a new session for the user, on this request.
+func newSessionInfo(c echo.Context, sess *sessions.Session, userID string) sessionstore.Info {
+	now := time.Now()
+	ip, userAgent := sessionClient(c, sess)
+	return sessionstore.Info{
+		UserID:     userID,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		IP:         ip,
+		UserAgent:  userAgent,
+	}
+}
+
+// sessionClient returns the IP address and browser of the request, for the
+// sessions page. They're left out of sessions kept in the cookie, which can
+// only be 4KB, and aren't listed anyway.
+func sessionClient(c echo.Context, sess *sessions.Session) (ip, userAgent string) {
+	if _, ok := sess.Store().(*sessionstore.Store); !ok {
+		return "", ""
+	}
+	return c.RealIP(), c.Request().UserAgent()
+}
+
+// sessionExpired returns why the session has timed out, or "" if it hasn't.
+func sessionExpired(info sessionstore.Info, idleTimeout, maxAge time.Duration) string {
+	now := time.Now()
+	if idleTimeout > 0 && now.Sub(info.LastSeenAt) > idleTimeout {
+		return "idle"
+	}
+	if maxAge > 0 && now.Sub(info.CreatedAt) > maxAge {
+		return "max_age"
+	}
+	return ""
+}
+
+// trackSession logs the user out if their session has been idle too long or
+// is too old, returning nil. Otherwise it records when, where from and with
+// what browser they were last seen, and returns the user.
+func (a *Auth) trackSession(c echo.Context, user *models.User) *models.User {
+	sess, err := a.getSession(c)
+	if err != nil {
+		a.logger.Error("trackSession: failed to get session", "error", err)
+		return user
+	}
+
+	info, ok := sess.Values[sessionstore.InfoKey].(sessionstore.Info)
+	if !ok {
+		// Sessions from before the timeouts, which start from now
+		info = newSessionInfo(c, sess, user.ID)
+	}
+
+	if reason := sessionExpired(info, a.cfg.IdleTimeout, a.cfg.MaxSessionAge); reason != "" {
+		a.logger.Info("trackSession: session timed out, logging out", "userID", user.ID, "reason", reason)
+		a.logoutAndRevoke(c)
+		return nil
+	}
+
+	ip, userAgent := sessionClient(c, sess)
+	if ok && time.Since(info.LastSeenAt) < lastSeenUpdateInterval && info.IP == ip && info.UserAgent == userAgent {
+		return user
+	}
+
+	info.LastSeenAt = time.Now()
+	info.IP = ip
+	info.UserAgent = userAgent
+	sess.Values[sessionstore.InfoKey] = info
+	if err := sess.Save(c.Request(), c.Response()); err != nil {
+		a.logger.Error("trackSession: failed to save session", "error", err)
+	}
+
+	return user
+}
+
+func (t sessionTokens) needsRefresh() bool {
+	return time.Until(t.ExpiresAt) < tokenRefreshWindow
+}
+
+// renewSession refreshes the user's Cognito tokens when they are close to
+// expiring, and updates the user from the new ID token. It returns the user to
+// use for this request, which is nil if Cognito no longer accepts the refresh
+// token, in which case the user is logged out.
+func (a *Auth) renewSession(c echo.Context, user *models.User) *models.User {
+	sess, err := a.getSession(c)
+	if err != nil {
+		a.logger.Error("renewSession: failed to get session", "error", err)
+		return user
+	}
+
+	tokens, ok := sess.Values[sessionTokensKey].(sessionTokens)
+	if !ok || !tokens.needsRefresh() {
+		return user
+	}
+
+	ctx := c.Request().Context()
+	tokenResponse, err := a.refreshTokens(ctx, tokens.RefreshToken)
+	if errors.Is(err, ErrInvalidGrant) {
+		// The refresh token expired or was revoked, or the user was disabled
+		a.logger.Info("renewSession: refresh token rejected, logging out", "userID", user.ID, "error", err)
+		a.logout(c)
+		return nil
+	}
+	if err != nil {
+		// Most likely a temporary problem reaching Cognito, so keep the user and
+		// try again on the next request.
+		a.logger.Error("renewSession: failed to refresh tokens", "userID", user.ID, "error", err)
+		return user
+	}
+
+	claims, err := a.verifier.verifyIDToken(ctx, tokenResponse.IDToken, "")
+	if err != nil {
+		a.logger.Error("renewSession: failed to verify refreshed ID token", "userID", user.ID, "error", err)
+		return user
+	}
+
+	if claims.Subject != user.ID {
+		a.logger.Error("renewSession: refreshed ID token is for a different user", "userID", user.ID, "sub", claims.Subject)
+		a.logout(c)
+		return nil
+	}
+
+	refreshed := userFromClaims(claims)
+	if !sameGroups(user.Groups, refreshed.Groups) {
+		// The user's roles changed, so they get a new session ID
+		a.logger.Info("renewSession: user's groups changed, regenerating session", "userID", user.ID)
+		if err := a.regenerateSession(c, sess, sessionstore.InfoKey); err != nil {
+			a.logger.Error("renewSession: failed to regenerate session", "error", err)
+		}
+	}
+	sess.Values[sessionUserKey] = refreshed
+	sess.Values[sessionTokensKey] = newSessionTokens(tokenResponse, tokens.RefreshToken)
+	if err := sess.Save(c.Request(), c.Response()); err != nil {
+		a.logger.Error("renewSession: failed to save session", "error", err)
+	}
+
+	a.logger.Info("renewSession: refreshed tokens", "userID", user.ID)
+	return &refreshed
+}
+
+// revokeTimeout limits how long logging out waits for Cognito to revoke the
+// refresh token.
+const revokeTimeout = 2 * time.Second
+
+// logoutAndRevoke ends the session, then revokes its refresh token at Cognito.
+// The revocation is only tried once, with a short deadline, and failures are
+// only logged, as a slow or failing Cognito must never stop the user logging
+// out.
+func (a *Auth) logoutAndRevoke(c echo.Context) {
+	var refreshToken string
+	if sess, err := a.getSession(c); err == nil {
+		tokens, _ := sess.Values[sessionTokensKey].(sessionTokens)
+		refreshToken = tokens.RefreshToken
+	}
+
+	a.logout(c)
+	if refreshToken == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), revokeTimeout)
+	defer cancel()
+	if err := a.revokeRefreshToken(ctx, refreshToken); err != nil {
+		a.logger.Error("logoutAndRevoke: failed to revoke refresh token", "error", err)
+	}
+}
+
+// getSession returns the user's session. A cookie that can't be decoded (e.g.
+// because its key has been rotated out, or it was tampered with) is logged and
+// dropped, so the user gets a new session rather than an error. The server side
+// sessionstore.Store does this itself, this is for other stores, such as
+// gorilla's cookie store.
+func (a *Auth) getSession(c echo.Context) (*sessions.Session, error) {
+	sess, err := session.Get(a.cfg.SessionName, c)
+	var cookieErr securecookie.Error
+	if errors.As(err, &cookieErr) && cookieErr.IsDecode() && sess != nil {
+		a.logger.Warn("getSession: ignoring session cookie that can't be decoded", "error", err)
+		return sess, nil
+	}
+	return sess, err
+}
+
+func (a *Auth) userFromSession(c echo.Context) *models.User {
+	sess, err := a.getSession(c)
+	if err != nil {
+		a.logger.Error("User: failed to get session", "error", err)
+		return nil
+	}
+
+	if user, ok := sess.Values[sessionUserKey]; ok {
+		var curUser = models.User{}
+		if curUser, ok = user.(models.User); !ok {
+			a.logger.Error("User: user is not the proper type", "user", user)
+			return nil
+		}
+
+		return &curUser
+	}
+
+	return nil
+}
+
+// sessionRegenerator is implemented by stores that keep sessions on the server
+// by ID, such as sessionstore.Store.
+type sessionRegenerator interface {
+	Regenerate(r *http.Request, session *sessions.Session, keep ...any) error
+}
+
+// regenerateSession gives the session a new ID (if its store has IDs), keeping
+// only the values with the keep keys. The session must be saved afterwards.
+func (a *Auth) regenerateSession(c echo.Context, sess *sessions.Session, keep ...any) error {
+	if store, ok := sess.Store().(sessionRegenerator); ok {
+		return store.Regenerate(c.Request(), sess, keep...)
+	}
+
+	// Other stores (e.g. the cookie store) keep the whole session in the cookie,
+	// so there's no ID to change
+	for key := range sess.Values {
+		if !slices.Contains(keep, key) {
+			delete(sess.Values, key)
+		}
+	}
+	return nil
+}
+
+// sameGroups reports whether the two lists have the same groups, in any order.
+func sameGroups(a, b []string) bool {
+	return slices.Equal(slices.Sorted(slices.Values(a)), slices.Sorted(slices.Values(b)))
+}
+
+// logout deletes the session, so the user gets a new one (with a new ID) on
+// their next request.
+func (a *Auth) logout(c echo.Context) error {
+	sess, err := a.getSession(c)
+	// ignore error fetching session, as means we don't have one (most likely)
+	// also ignore error saving session, but log it in case, as it's unexpected
+	if err == nil {
+		sess.Options.MaxAge = -1 // deletes the session
+		if err := sess.Save(c.Request(), c.Response()); err != nil {
+			a.logger.Error("logout: failed to save session", "error", err)
+		}
+	}
+
+	return nil
+}
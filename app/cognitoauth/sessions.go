@@ -0,0 +1,159 @@
+package cognitoauth
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"echo-cognito-auth/models"
+	"echo-cognito-auth/sessionstore"
+)
+
+// SessionHandleParam is the form value with the handle of the session for
+// RevokeSessionHandler to revoke.
+const SessionHandleParam = "session"
+
+// UserSessions returns the logged in user's active sessions, most recently used
+// first. Only sessionstore.Store can list them, so with other stores it's just
+// the current session.
+func (a *Auth) UserSessions(c echo.Context) ([]models.Session, error) {
+	user := UserFromContext(c)
+	if user == nil {
+		return nil, nil
+	}
+
+	sess, err := a.getSession(c)
+	if err != nil {
+		return nil, err
+	}
+
+	store, ok := sess.Store().(*sessionstore.Store)
+	if !ok {
+		info, _ := sess.Values[sessionstore.InfoKey].(sessionstore.Info)
+		return []models.Session{newModelSession("", info, true)}, nil
+	}
+
+	userSessions, err := store.UserSessions(c.Request().Context(), user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	current := sessionstore.Handle(sess)
+	sessions := make([]models.Session, 0, len(userSessions))
+	for _, us := range userSessions {
+		sessions = append(sessions, newModelSession(us.Handle, us.Info, us.Handle == current))
+	}
+	return sessions, nil
+}
+
+func newModelSession(handle string, info sessionstore.Info, current bool) models.Session {
+	return models.Session{
+		Handle:     handle,
+		CreatedAt:  info.CreatedAt,
+		LastSeenAt: info.LastSeenAt,
+		IP:         info.IP,
+		UserAgent:  info.UserAgent,
+		Current:    current,
+	}
+}
+
+// RevokeSessionHandler ends one of the logged in user's sessions, chosen by the
+// SessionHandleParam form value, then returns to the ReturnToParam path (or
+// /user). Revoking the current session logs the user out. It needs
+// sessionstore.Store. Use it for POSTs, behind RequireAuth and CSRF protection.
+func (a *Auth) RevokeSessionHandler(c echo.Context) error {
+	user := UserFromContext(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized)
+	}
+
+	sess, err := a.getSession(c)
+	if err != nil {
+		a.logger.Error("RevokeSessionHandler: failed to get session", "error", err)
+		return err
+	}
+
+	store, ok := sess.Store().(*sessionstore.Store)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "Sessions can't be revoked individually with this session store.")
+	}
+
+	handle := c.FormValue(SessionHandleParam)
+	err = store.DeleteUserSession(c.Request().Context(), user.ID, handle)
+	if errors.Is(err, sessionstore.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "That session has already ended.")
+	}
+	if err != nil {
+		a.logger.Error("RevokeSessionHandler: failed to delete session", "userID", user.ID, "error", err)
+		return err
+	}
+
+	a.logger.Info("RevokeSessionHandler: revoked session", "userID", user.ID, "current", handle == sessionstore.Handle(sess))
+	if handle == sessionstore.Handle(sess) {
+		a.logoutAndRevoke(c)
+		return c.Redirect(http.StatusSeeOther, "/")
+	}
+
+	returnTo := safeReturnPath(c.FormValue(ReturnToParam))
+	if returnTo == "" {
+		returnTo = "/user"
+	}
+	return c.Redirect(http.StatusSeeOther, returnTo)
+}
+
+// SignOutEverywhereHandler signs the logged in user out of all their sessions,
+// and out of Cognito on every device (if the access token has ScopeUserAdmin),
+// then sends them to the Cognito logout page like LogoutHandler. Use it for
+// POSTs, behind RequireAuth and CSRF protection.
+func (a *Auth) SignOutEverywhereHandler(c echo.Context) error {
+	user := UserFromContext(c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized)
+	}
+
+	sess, err := a.getSession(c)
+	if err != nil {
+		a.logger.Error("SignOutEverywhereHandler: failed to get session", "error", err)
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	// Failing to sign out of Cognito mustn't stop the sessions here ending.
+	// Without it, sessions in other stores (i.e. cookies) last until their
+	// tokens next need refreshing.
+	signedOut := false
+	if tokens, ok := sess.Values[sessionTokensKey].(sessionTokens); ok && tokens.RefreshToken != "" {
+		if err := a.signOutOfCognito(ctx, tokens.RefreshToken); err != nil {
+			a.logger.Error("SignOutEverywhereHandler: failed to sign out of Cognito", "userID", user.ID, "error", err)
+		} else {
+			signedOut = true
+		}
+	}
+
+	if store, ok := sess.Store().(*sessionstore.Store); ok {
+		count, err := store.DeleteUserSessions(ctx, user.ID)
+		if err != nil {
+			a.logger.Error("SignOutEverywhereHandler: failed to delete sessions", "userID", user.ID, "error", err)
+			return err
+		}
+		a.logger.Info("SignOutEverywhereHandler: deleted sessions", "userID", user.ID, "count", count)
+	}
+
+	if a.cfg.OnLogout != nil {
+		a.cfg.OnLogout(c, user)
+	}
+
+	// Signing out of Cognito already invalidated the refresh token
+	if signedOut {
+		a.logout(c)
+	} else {
+		a.logoutAndRevoke(c)
+	}
+	logoutURL := a.hostedLogoutURL(c.Request().Host)
+	if logoutURL == "" {
+		logoutURL = "/"
+	}
+	return c.Redirect(http.StatusSeeOther, logoutURL)
+}
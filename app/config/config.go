@@ -0,0 +1,575 @@
+// Package config loads the app's configuration. Values come from (in order of
+// precedence, highest first):
+//
+//  1. environment variables
+//  2. the stage's section under "stages" in the config file
+//  3. the rest of the config file
+//  4. defaults
+//
+// The config file is optional, and is named by ECHO_COGNITO_AUTH_CONFIG_FILE.
+// It can be YAML or JSON.
+package config
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	StageDev        = "dev"
+	StageProduction = "production"
+
+	// MinSessionSecretLength is the minimum session secret (and session hash
+	// key) length, in bytes.
+	MinSessionSecretLength = 32
+	// SessionEncryptionKeyLength is the session encryption key length, in bytes
+	// (AES-256).
+	SessionEncryptionKeyLength = 32
+
+	// HostCookiePrefix is added to the session cookie name when HostPrefix is
+	// set. Browsers only accept such cookies if they're Secure, for the whole
+	// site (Path=/) and have no Domain, so they can't be set by subdomains.
+	HostCookiePrefix = "__Host-"
+
+	// The session stores, which keep the session data on the server, so the
+	// cookie only holds its ID. SessionStoreCookie, which kept it all in the
+	// cookie, is no longer supported.
+	SessionStoreCookie   = "cookie"
+	SessionStoreMemory   = "memory"
+	SessionStoreBolt     = "bolt"
+	SessionStoreDynamoDB = "dynamodb"
+)
+
+// Config is the app's configuration.
+type Config struct {
+	// Stage is the deployment stage, e.g. "dev" or "production".
+	Stage string `yaml:"stage"`
+	// AppURL is the app's public URL (scheme and host), e.g. https://example.com
+	AppURL string `yaml:"appURL"`
+
+	Server  ServerConfig  `yaml:"server"`
+	Cognito CognitoConfig `yaml:"cognito"`
+	Session SessionConfig `yaml:"session"`
+}
+
+// ServerConfig is for serving the app locally, or anywhere other than Lambda.
+type ServerConfig struct {
+	// Address is the address to listen on, e.g. ":8080" or "localhost:3000".
+	Address string `yaml:"address"`
+	// TLSCertFile and TLSKeyFile are PEM files with the certificate and key to
+	// serve https with. Both or neither must be set.
+	TLSCertFile string `yaml:"tlsCertFile"`
+	TLSKeyFile  string `yaml:"tlsKeyFile"`
+	// ShutdownTimeout is how long to wait for requests in progress to finish
+	// when stopping, after which they're cancelled.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+type CognitoConfig struct {
+	// ClientID is the user pool app client ID.
+	ClientID string `yaml:"clientID"`
+	// ClientSecret is optional, public app clients don't have one.
+	ClientSecret string `yaml:"clientSecret"`
+	// BaseURL is the user pool domain, e.g. https://<domain>.auth.<region>.amazoncognito.com
+	// Optional, it's the fallback for endpoints not found by OIDC discovery.
+	BaseURL string `yaml:"baseURL"`
+	// RedirectURI is the full URL of the login callback route.
+	RedirectURI string `yaml:"redirectURI"`
+	// IssuerURL is the user pool issuer, https://cognito-idp.<region>.amazonaws.com/<poolId>
+	IssuerURL string `yaml:"issuerURL"`
+	// DisableDiscovery uses the Cognito URL layout under BaseURL instead of
+	// loading the endpoints from the issuer's OIDC discovery document.
+	DisableDiscovery bool `yaml:"disableDiscovery"`
+	// APIClientIDs are the app clients whose access tokens the API accepts.
+	APIClientIDs []string `yaml:"apiClientIDs"`
+	// APIScopes are the OAuth scopes every access token the API accepts must
+	// have, e.g. a resource server's custom scopes. None are required if empty.
+	APIScopes []string `yaml:"apiScopes"`
+	// HTTPTimeout is the timeout for each request to Cognito, e.g. "3s".
+	// Defaults to cognitoauth.DefaultClientTimeout.
+	HTTPTimeout time.Duration `yaml:"httpTimeout"`
+	// MaxRetries is how many times idempotent requests to Cognito are retried.
+	// Defaults to cognitoauth.DefaultMaxRetries, negative disables retries.
+	MaxRetries int `yaml:"maxRetries"`
+}
+
+type SessionConfig struct {
+	// Keys sign and encrypt the session cookie, newest first. New cookies use
+	// the first pair, and cookies made with any of the others are still
+	// accepted, so keys can be rotated without logging everyone out.
+	Keys []SessionKey `yaml:"keys"`
+	// Secret is the original single session secret. If set, it is used as the
+	// oldest key pair (with an encryption key derived from it), and cookies
+	// that were only signed with it, as they were before Keys, are still
+	// accepted, so existing cookies keep working after moving to Keys.
+	Secret string `yaml:"secret"`
+	// Store is where sessions are kept: SessionStoreMemory (the default, for
+	// local development), SessionStoreBolt or SessionStoreDynamoDB.
+	// SessionStoreCookie is rejected, see Validate.
+	Store string `yaml:"store"`
+	// TTL is how long a session lasts after it was last saved, e.g. "24h".
+	TTL time.Duration `yaml:"ttl"`
+	// BoltPath is the bolt store's database file.
+	BoltPath string `yaml:"boltPath"`
+	// DynamoDBTable is the dynamodb store's table.
+	DynamoDBTable string `yaml:"dynamoDBTable"`
+	// DynamoDBEndpoint overrides the DynamoDB endpoint, e.g. to use DynamoDB
+	// Local at http://localhost:8000
+	DynamoDBEndpoint string `yaml:"dynamoDBEndpoint"`
+
+	// IdleTimeout logs the user out after this long without a request, and
+	// MaxLifetime this long after they logged in, however active they are. Zero
+	// disables either.
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+	MaxLifetime time.Duration `yaml:"maxLifetime"`
+
+	// CookieName is the session cookie's name, without the __Host- prefix.
+	CookieName string `yaml:"cookieName"`
+	// CookieDomain lets the cookie be sent to subdomains, e.g. "example.com".
+	// By default it's only sent to the app's host.
+	CookieDomain string `yaml:"cookieDomain"`
+	// HostPrefix adds the HostCookiePrefix to the cookie name.
+	HostPrefix bool `yaml:"hostPrefix"`
+}
+
+// SessionKey is a session cookie key pair.
+type SessionKey struct {
+	// Hash authenticates the cookie (HMAC-SHA256), at least
+	// MinSessionSecretLength bytes.
+	Hash string `yaml:"hash"`
+	// Encryption encrypts the cookie (AES-256), SessionEncryptionKeyLength bytes.
+	Encryption string `yaml:"encryption"`
+}
+
+// KeyPairs returns the session key pairs, newest first, in the form taken by
+// gorilla/sessions stores.
+func (c SessionConfig) KeyPairs() [][]byte {
+	var pairs [][]byte
+	for _, key := range c.Keys {
+		pairs = append(pairs, []byte(key.Hash), []byte(key.Encryption))
+	}
+
+	if c.Secret != "" {
+		pairs = append(pairs, []byte(c.Secret), secretEncryptionKey(c.Secret))
+		// Cookies from before the keys were only signed
+		pairs = append(pairs, []byte(c.Secret), nil)
+	}
+
+	return pairs
+}
+
+// Name returns the session cookie's name, with the host prefix if enabled.
+func (c SessionConfig) Name() string {
+	if c.HostPrefix {
+		return HostCookiePrefix + c.CookieName
+	}
+	return c.CookieName
+}
+
+// SecureCookies reports whether cookies must only be sent over https, which is
+// whenever the app isn't on localhost, or the host prefix requires it.
+func (c *Config) SecureCookies() bool {
+	if c.Session.HostPrefix {
+		return true
+	}
+
+	u, err := url.Parse(c.AppURL)
+	return err != nil || u.Hostname() != "localhost"
+}
+
+// secretEncryptionKey derives the AES-256 key used to encrypt the session
+// cookie from the single session secret.
+func secretEncryptionKey(secret string) []byte {
+	key := sha256.Sum256([]byte("echo-cognito-auth session encryption:" + secret))
+	return key[:]
+}
+
+// ValidationError lists every problem found with the config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Defaults returns the config used for anything not otherwise set. It isn't
+// valid on its own, as it has no Cognito settings or session keys.
+func Defaults(stage string) Config {
+	return Config{
+		Stage:  stage,
+		AppURL: "http://localhost:8080",
+		Server: ServerConfig{
+			Address:         ":8080",
+			ShutdownTimeout: 10 * time.Second,
+		},
+		Session: SessionConfig{
+			Store:       SessionStoreMemory,
+			TTL:         24 * time.Hour,
+			BoltPath:    "sessions.db",
+			IdleTimeout: 2 * time.Hour,
+			MaxLifetime: 24 * time.Hour,
+			CookieName:  "session",
+		},
+	}
+}
+
+// Load loads and validates the config. The stage comes from
+// ECHO_COGNITO_AUTH_STAGE, or defaultStage if that isn't set.
+func Load(defaultStage string) (*Config, error) {
+	stage := os.Getenv("ECHO_COGNITO_AUTH_STAGE")
+	if stage == "" {
+		stage = defaultStage
+	}
+
+	cfg := Defaults(stage)
+
+	if path := os.Getenv("ECHO_COGNITO_AUTH_CONFIG_FILE"); path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	// Environment variables that can't be parsed are reported along with the
+	// rest of the problems
+	envProblems := applyEnv(&cfg)
+
+	if err := cfg.Validate(); err != nil || len(envProblems) > 0 {
+		var validationErr *ValidationError
+		if err != nil && !errors.As(err, &validationErr) {
+			return nil, err
+		}
+		problems := envProblems
+		if validationErr != nil {
+			problems = append(problems, validationErr.Problems...)
+		}
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	return &cfg, nil
+}
+
+// loadFile reads the config file into cfg, and then the overrides for cfg's
+// stage, if it has any. JSON is valid YAML, so this handles both.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var file struct {
+		Config `yaml:",inline"`
+		Stages map[string]yaml.Node `yaml:"stages"`
+	}
+	file.Config = *cfg
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	*cfg = file.Config
+
+	if overrides, ok := file.Stages[cfg.Stage]; ok {
+		stage := cfg.Stage
+		if err := overrides.Decode(cfg); err != nil {
+			return fmt.Errorf("failed to parse %s stage in config file %s: %w", stage, path, err)
+		}
+		cfg.Stage = stage
+	}
+
+	return nil
+}
+
+// applyEnv overrides cfg with any environment variables that are set, and
+// returns the problems with any that can't be parsed.
+func applyEnv(cfg *Config) []string {
+	var problems []string
+	setFromEnv(&cfg.AppURL, "APP_URL")
+	setFromEnv(&cfg.Server.Address, "ECHO_COGNITO_AUTH_LISTEN_ADDRESS")
+	setFromEnv(&cfg.Server.TLSCertFile, "ECHO_COGNITO_AUTH_TLS_CERT_FILE")
+	setFromEnv(&cfg.Server.TLSKeyFile, "ECHO_COGNITO_AUTH_TLS_KEY_FILE")
+	setDurationFromEnv(&cfg.Server.ShutdownTimeout, "ECHO_COGNITO_AUTH_SHUTDOWN_TIMEOUT", &problems)
+	setFromEnv(&cfg.Cognito.ClientID, "COGNITO_USER_POOL_CLIENT_ID")
+	setFromEnv(&cfg.Cognito.ClientSecret, "COGNITO_USER_POOL_CLIENT_SECRET")
+	setFromEnv(&cfg.Cognito.BaseURL, "COGNITO_BASE_URL")
+	setFromEnv(&cfg.Cognito.RedirectURI, "COGNITO_REDIRECT_URI")
+	setFromEnv(&cfg.Cognito.IssuerURL, "COGNITO_ISSUER_URL")
+	setBoolFromEnv(&cfg.Cognito.DisableDiscovery, "COGNITO_DISABLE_DISCOVERY", &problems)
+	setListFromEnv(&cfg.Cognito.APIClientIDs, "COGNITO_API_CLIENT_IDS")
+	setListFromEnv(&cfg.Cognito.APIScopes, "COGNITO_API_SCOPES")
+	setDurationFromEnv(&cfg.Cognito.HTTPTimeout, "COGNITO_HTTP_TIMEOUT", &problems)
+	setIntFromEnv(&cfg.Cognito.MaxRetries, "COGNITO_MAX_RETRIES", &problems)
+	setKeysFromEnv(&cfg.Session.Keys, "ECHO_COGNITO_AUTH_SESSION_KEYS", &problems)
+	setFromEnv(&cfg.Session.Secret, "ECHO_COGNITO_AUTH_SESSION_SECRET")
+	setFromEnv(&cfg.Session.Store, "ECHO_COGNITO_AUTH_SESSION_STORE")
+	setDurationFromEnv(&cfg.Session.TTL, "ECHO_COGNITO_AUTH_SESSION_TTL", &problems)
+	setFromEnv(&cfg.Session.BoltPath, "ECHO_COGNITO_AUTH_SESSION_BOLT_PATH")
+	setFromEnv(&cfg.Session.DynamoDBTable, "ECHO_COGNITO_AUTH_SESSION_TABLE")
+	setFromEnv(&cfg.Session.DynamoDBEndpoint, "DYNAMODB_ENDPOINT")
+	setDurationFromEnv(&cfg.Session.IdleTimeout, "ECHO_COGNITO_AUTH_SESSION_IDLE_TIMEOUT", &problems)
+	setDurationFromEnv(&cfg.Session.MaxLifetime, "ECHO_COGNITO_AUTH_SESSION_MAX_LIFETIME", &problems)
+	setFromEnv(&cfg.Session.CookieName, "ECHO_COGNITO_AUTH_SESSION_COOKIE_NAME")
+	setFromEnv(&cfg.Session.CookieDomain, "ECHO_COGNITO_AUTH_SESSION_COOKIE_DOMAIN")
+	setBoolFromEnv(&cfg.Session.HostPrefix, "ECHO_COGNITO_AUTH_SESSION_COOKIE_HOST_PREFIX", &problems)
+
+	return problems
+}
+
+func setFromEnv(field *string, name string) {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		*field = v
+	}
+}
+
+// setBoolFromEnv, setDurationFromEnv and setIntFromEnv add a problem if the
+// variable is set but can't be parsed, rather than ignoring it.
+func setBoolFromEnv(field *bool, name string, problems *[]string) {
+	s := os.Getenv(name)
+	if s == "" {
+		return
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		*problems = append(*problems, fmt.Sprintf("%s must be true or false, got %q", name, s))
+		return
+	}
+	*field = v
+}
+
+func setDurationFromEnv(field *time.Duration, name string, problems *[]string) {
+	s := os.Getenv(name)
+	if s == "" {
+		return
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		*problems = append(*problems, fmt.Sprintf("%s must be a duration, e.g. 30s or 24h, got %q", name, s))
+		return
+	}
+	*field = v
+}
+
+func setIntFromEnv(field *int, name string, problems *[]string) {
+	s := os.Getenv(name)
+	if s == "" {
+		return
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		*problems = append(*problems, fmt.Sprintf("%s must be a whole number, got %q", name, s))
+		return
+	}
+	*field = v
+}
+
+// setKeysFromEnv sets the session keys from a comma separated list of
+// hash:encryption pairs. Pairs without exactly one colon (e.g. because a key
+// has a colon or comma in it) are problems, and left out.
+func setKeysFromEnv(field *[]SessionKey, name string, problems *[]string) {
+	var keys []SessionKey
+	pair := 0
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+
+		hash, encryption, ok := strings.Cut(v, ":")
+		if !ok || strings.Contains(encryption, ":") {
+			*problems = append(*problems, fmt.Sprintf("%s pair %d must be hash:encryption, and the keys can't contain : or ,", name, pair))
+		} else {
+			keys = append(keys, SessionKey{Hash: hash, Encryption: encryption})
+		}
+		pair++
+	}
+
+	if len(keys) > 0 {
+		*field = keys
+	}
+}
+
+// setListFromEnv sets the field from a comma separated list.
+func setListFromEnv(field *[]string, name string) {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	if len(values) > 0 {
+		*field = values
+	}
+}
+
+// sessionKeySymbols are the characters other than letters and digits that
+// session keys can have, which covers hex, base64 and base64url keys. Keys
+// can't have the : and , that separate them in ECHO_COGNITO_AUTH_SESSION_KEYS.
+const sessionKeySymbols = "-_.+/="
+
+// validSessionKey reports whether the session key only has letters, digits and
+// sessionKeySymbols.
+func validSessionKey(key string) bool {
+	for _, r := range key {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || strings.ContainsRune(sessionKeySymbols, r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks the whole config, returning a *ValidationError listing all of
+// the problems found.
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Stage == "" {
+		add("stage is required")
+	}
+
+	appURL, err := parseURL(c.AppURL, false)
+	if err != nil {
+		add("appURL (APP_URL) %v", err)
+	}
+
+	if _, _, err := net.SplitHostPort(c.Server.Address); err != nil {
+		add("server.address (ECHO_COGNITO_AUTH_LISTEN_ADDRESS) must be host:port, e.g. :8080, got %q", c.Server.Address)
+	}
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		add("server.tlsCertFile (ECHO_COGNITO_AUTH_TLS_CERT_FILE) and server.tlsKeyFile (ECHO_COGNITO_AUTH_TLS_KEY_FILE) must both be set for https")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		add("server.shutdownTimeout (ECHO_COGNITO_AUTH_SHUTDOWN_TIMEOUT) must be positive, got %s", c.Server.ShutdownTimeout)
+	}
+
+	if c.Cognito.ClientID == "" {
+		add("cognito.clientID (COGNITO_USER_POOL_CLIENT_ID) is required")
+	}
+
+	if c.Cognito.BaseURL == "" {
+		if c.Cognito.DisableDiscovery {
+			add("cognito.baseURL (COGNITO_BASE_URL) is required when discovery is disabled")
+		}
+	} else if u, err := parseURL(c.Cognito.BaseURL, true); err != nil {
+		add("cognito.baseURL (COGNITO_BASE_URL) %v", err)
+	} else if u.Path != "" && u.Path != "/" {
+		add("cognito.baseURL (COGNITO_BASE_URL) must not have a path, got %q", u.Path)
+	}
+
+	if u, err := parseURL(c.Cognito.IssuerURL, true); err != nil {
+		add("cognito.issuerURL (COGNITO_ISSUER_URL) %v", err)
+	} else if poolID := strings.Trim(u.Path, "/"); strings.HasSuffix(u.Host, ".amazonaws.com") && (poolID == "" || strings.Contains(poolID, "/")) {
+		add("cognito.issuerURL (COGNITO_ISSUER_URL) must be https://cognito-idp.<region>.amazonaws.com/<poolId>, got %q", c.Cognito.IssuerURL)
+	}
+
+	if c.Cognito.HTTPTimeout < 0 {
+		add("cognito.httpTimeout (COGNITO_HTTP_TIMEOUT) must not be negative, got %s", c.Cognito.HTTPTimeout)
+	}
+
+	if u, err := parseURL(c.Cognito.RedirectURI, false); err != nil {
+		add("cognito.redirectURI (COGNITO_REDIRECT_URI) %v", err)
+	} else if appURL != nil && (u.Scheme != appURL.Scheme || u.Host != appURL.Host) {
+		add("cognito.redirectURI (COGNITO_REDIRECT_URI) %q must be on the app's host %s://%s", c.Cognito.RedirectURI, appURL.Scheme, appURL.Host)
+	}
+
+	if len(c.Session.Keys) == 0 && c.Session.Secret == "" {
+		add("session.keys (ECHO_COGNITO_AUTH_SESSION_KEYS) is required")
+	}
+	for i, key := range c.Session.Keys {
+		switch {
+		case !validSessionKey(key.Hash):
+			add("session.keys[%d] (ECHO_COGNITO_AUTH_SESSION_KEYS) hash key may only contain letters, digits and %s (e.g. hex or base64)", i, sessionKeySymbols)
+		case len(key.Hash) < MinSessionSecretLength:
+			add("session.keys[%d] (ECHO_COGNITO_AUTH_SESSION_KEYS) hash key must be at least %d characters", i, MinSessionSecretLength)
+		}
+		switch {
+		case !validSessionKey(key.Encryption):
+			add("session.keys[%d] (ECHO_COGNITO_AUTH_SESSION_KEYS) encryption key may only contain letters, digits and %s (e.g. hex or base64)", i, sessionKeySymbols)
+		case len(key.Encryption) != SessionEncryptionKeyLength:
+			add("session.keys[%d] (ECHO_COGNITO_AUTH_SESSION_KEYS) encryption key must be %d characters", i, SessionEncryptionKeyLength)
+		}
+	}
+	if c.Session.Secret != "" && len(c.Session.Secret) < MinSessionSecretLength {
+		add("session.secret (ECHO_COGNITO_AUTH_SESSION_SECRET) must be at least %d characters", MinSessionSecretLength)
+	}
+
+	switch c.Session.Store {
+	case SessionStoreMemory:
+	case SessionStoreCookie:
+		// Cognito's refresh token alone is nearly 4KB once encoded, which
+		// leaves no room in the cookie for the rest of the session
+		add("session.store (ECHO_COGNITO_AUTH_SESSION_STORE) %s is no longer supported, as a session with Cognito's tokens doesn't fit in a cookie, use %s, %s or %s",
+			SessionStoreCookie, SessionStoreMemory, SessionStoreBolt, SessionStoreDynamoDB)
+	case SessionStoreBolt:
+		if c.Session.BoltPath == "" {
+			add("session.boltPath (ECHO_COGNITO_AUTH_SESSION_BOLT_PATH) is required for the bolt store")
+		}
+	case SessionStoreDynamoDB:
+		if c.Session.DynamoDBTable == "" {
+			add("session.dynamoDBTable (ECHO_COGNITO_AUTH_SESSION_TABLE) is required for the dynamodb store")
+		}
+		if c.Session.DynamoDBEndpoint != "" {
+			if _, err := parseURL(c.Session.DynamoDBEndpoint, false); err != nil {
+				add("session.dynamoDBEndpoint (DYNAMODB_ENDPOINT) %v", err)
+			}
+		}
+	default:
+		add("session.store (ECHO_COGNITO_AUTH_SESSION_STORE) must be one of %s, %s or %s, got %q",
+			SessionStoreMemory, SessionStoreBolt, SessionStoreDynamoDB, c.Session.Store)
+	}
+
+	if c.Session.TTL < time.Second {
+		add("session.ttl (ECHO_COGNITO_AUTH_SESSION_TTL) must be at least 1s, got %s", c.Session.TTL)
+	}
+	if c.Session.IdleTimeout < 0 {
+		add("session.idleTimeout (ECHO_COGNITO_AUTH_SESSION_IDLE_TIMEOUT) must not be negative, got %s", c.Session.IdleTimeout)
+	}
+	if c.Session.MaxLifetime < 0 {
+		add("session.maxLifetime (ECHO_COGNITO_AUTH_SESSION_MAX_LIFETIME) must not be negative, got %s", c.Session.MaxLifetime)
+	}
+
+	if c.Session.CookieName == "" || strings.ContainsAny(c.Session.CookieName, " \t;,=\"") {
+		add("session.cookieName (ECHO_COGNITO_AUTH_SESSION_COOKIE_NAME) must be a valid cookie name, got %q", c.Session.CookieName)
+	}
+	if c.Session.HostPrefix && c.Session.CookieDomain != "" {
+		add("session.cookieDomain (ECHO_COGNITO_AUTH_SESSION_COOKIE_DOMAIN) can't be set with session.hostPrefix, %s cookies can't have a domain", HostCookiePrefix)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+// parseURL checks the value is an absolute http(s) URL. Only https is allowed
+// when requireHTTPS is set, apart from for localhost.
+func parseURL(value string, requireHTTPS bool) (*url.URL, error) {
+	if value == "" {
+		return nil, errors.New("is required")
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("is not a valid URL: %w", err)
+	}
+
+	if u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("must be an absolute http(s) URL, got %q", value)
+	}
+
+	if requireHTTPS && u.Scheme != "https" && u.Hostname() != "localhost" {
+		return nil, fmt.Errorf("must be an https URL, got %q", value)
+	}
+
+	return u, nil
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// setValidEnv sets the environment for a valid dev config.
+func setValidEnv(t *testing.T) {
+	t.Setenv("ECHO_COGNITO_AUTH_STAGE", StageDev)
+	t.Setenv("ECHO_COGNITO_AUTH_CONFIG_FILE", "")
+	t.Setenv("COGNITO_USER_POOL_CLIENT_ID", "client-id")
+	t.Setenv("COGNITO_USER_POOL_CLIENT_SECRET", "client-secret")
+	t.Setenv("COGNITO_BASE_URL", "https://auth.example.com")
+	t.Setenv("COGNITO_REDIRECT_URI", "http://localhost:8080/auth/cognito/callback")
+	t.Setenv("COGNITO_ISSUER_URL", "https://cognito-idp.eu-west-2.amazonaws.com/eu-west-2_example")
+	t.Setenv("ECHO_COGNITO_AUTH_SESSION_KEYS", strings.Repeat("h", MinSessionSecretLength)+":"+strings.Repeat("e", SessionEncryptionKeyLength))
+}
+
+func TestLoadEnv(t *testing.T) {
+	setValidEnv(t)
+	t.Setenv("ECHO_COGNITO_AUTH_SESSION_TTL", "2h")
+	t.Setenv("COGNITO_DISABLE_DISCOVERY", "true")
+	t.Setenv("COGNITO_MAX_RETRIES", "5")
+
+	cfg, err := Load(StageDev)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Session.TTL != 2*time.Hour || !cfg.Cognito.DisableDiscovery || cfg.Cognito.MaxRetries != 5 {
+		t.Errorf("Load() didn't apply the env: TTL %v, DisableDiscovery %v, MaxRetries %d", cfg.Session.TTL, cfg.Cognito.DisableDiscovery, cfg.Cognito.MaxRetries)
+	}
+}
+
+func TestLoadEnvParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"ECHO_COGNITO_AUTH_SESSION_TTL", "1day"},
+		{"COGNITO_DISABLE_DISCOVERY", "yes"},
+		{"COGNITO_MAX_RETRIES", "two"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setValidEnv(t)
+			t.Setenv(tt.name, tt.value)
+
+			_, err := Load(StageDev)
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Load() error = %v, want a ValidationError", err)
+			}
+			if !slices.ContainsFunc(validationErr.Problems, func(p string) bool {
+				return strings.Contains(p, tt.name) && strings.Contains(p, tt.value)
+			}) {
+				t.Errorf("Load() problems = %q, want one about %s=%s", validationErr.Problems, tt.name, tt.value)
+			}
+		})
+	}
+}
+
+func TestLoadEnvParseErrorsWithOtherProblems(t *testing.T) {
+	setValidEnv(t)
+	t.Setenv("COGNITO_MAX_RETRIES", "two")
+	t.Setenv("COGNITO_USER_POOL_CLIENT_ID", "")
+	t.Setenv("COGNITO_BASE_URL", "")
+
+	_, err := Load(StageDev)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Load() error = %v, want a ValidationError", err)
+	}
+	if len(validationErr.Problems) < 2 {
+		t.Errorf("Load() problems = %q, want the parse error and the validation problems", validationErr.Problems)
+	}
+}
+
+func TestKeyPairsAcceptLegacyCookies(t *testing.T) {
+	secret := strings.Repeat("s", MinSessionSecretLength)
+	cfg := SessionConfig{
+		Keys:   []SessionKey{{Hash: strings.Repeat("h", MinSessionSecretLength), Encryption: strings.Repeat("e", SessionEncryptionKeyLength)}},
+		Secret: secret,
+	}
+	codecs := securecookie.CodecsFromPairs(cfg.KeyPairs()...)
+
+	tests := []struct {
+		name  string
+		codec securecookie.Codec
+	}{
+		// Before the keys, cookies were only signed with the secret
+		{"signed with the secret", securecookie.New([]byte(secret), nil)},
+		{"encrypted with the secret", securecookie.New([]byte(secret), secretEncryptionKey(secret))},
+		{"newest key", codecs[0]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.codec.Encode("session", "value")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var value string
+			if err := securecookie.DecodeMulti("session", encoded, &value, codecs...); err != nil || value != "value" {
+				t.Errorf("DecodeMulti() = %q, %v, want value", value, err)
+			}
+		})
+	}
+
+	// New cookies are encrypted
+	encoded, err := securecookie.EncodeMulti("session", "value", codecs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value string
+	if err := securecookie.New([]byte(cfg.Keys[0].Hash), nil).Decode("session", encoded, &value); err == nil {
+		t.Error("new cookie decoded without the encryption key, want it encrypted")
+	}
+}
+
+func TestValidateRejectsCookieStore(t *testing.T) {
+	setValidEnv(t)
+	t.Setenv("ECHO_COGNITO_AUTH_SESSION_STORE", SessionStoreCookie)
+
+	_, err := Load(StageDev)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Load() error = %v, want a ValidationError", err)
+	}
+	if !slices.ContainsFunc(validationErr.Problems, func(p string) bool { return strings.Contains(p, "no longer supported") }) {
+		t.Errorf("Load() problems = %q, want one about the cookie store", validationErr.Problems)
+	}
+}
+
+func TestLoadEnvSessionKeys(t *testing.T) {
+	hash := strings.Repeat("h", MinSessionSecretLength)
+	encryption := strings.Repeat("e", SessionEncryptionKeyLength)
+
+	tests := []struct {
+		name    string
+		keys    string
+		want    int
+		problem string
+	}{
+		{"two pairs", hash + ":" + encryption + ", " + hash + "2:" + encryption, 2, ""},
+		{"base64", strings.Repeat("a+/=", 8) + ":" + strings.Repeat("b-_.", 8), 1, ""},
+		{"no colon", hash + encryption, 0, "pair 0 must be hash:encryption"},
+		{"colon in a key", "hash:" + hash + ":" + encryption, 0, "pair 0 must be hash:encryption"},
+		{"comma in a key", hash + ":" + encryption + "," + "hash," + hash + ":" + encryption, 0, "pair 1 must be hash:encryption"},
+		{"other characters", strings.Repeat("h!", 16) + ":" + encryption, 0, "hash key may only contain letters, digits and -_.+/="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setValidEnv(t)
+			t.Setenv("ECHO_COGNITO_AUTH_SESSION_KEYS", tt.keys)
+
+			cfg, err := Load(StageDev)
+			if tt.problem == "" {
+				if err != nil {
+					t.Fatalf("Load() error = %v", err)
+				}
+				if len(cfg.Session.Keys) != tt.want {
+					t.Errorf("Load() keys = %d, want %d", len(cfg.Session.Keys), tt.want)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Load() error = %v, want a ValidationError", err)
+			}
+			if !slices.ContainsFunc(validationErr.Problems, func(p string) bool { return strings.Contains(p, tt.problem) }) {
+				t.Errorf("Load() problems = %q, want one about %q", validationErr.Problems, tt.problem)
+			}
+			if slices.ContainsFunc(validationErr.Problems, func(p string) bool { return strings.Contains(p, "characters") }) {
+				t.Errorf("Load() problems = %q, want no confusing length problems", validationErr.Problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package lambdaadapter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// httpAPIEvent is an API Gateway HTTP API event, payload format 2.0.
+type httpAPIEvent struct {
+	events.APIGatewayV2HTTPRequest
+}
+
+func (e *httpAPIEvent) request(ctx context.Context) (*http.Request, error) {
+	header := http.Header{}
+	for name, value := range e.Headers {
+		// Repeated headers are already joined with commas
+		header.Set(name, value)
+	}
+	// Cookies are taken out of the headers
+	if len(e.Cookies) > 0 {
+		header.Set("Cookie", strings.Join(e.Cookies, "; "))
+	}
+
+	uri := e.RawPath
+	if e.RawQueryString != "" {
+		uri += "?" + e.RawQueryString
+	}
+
+	return newRequest(ctx, e.RequestContext.HTTP.Method, uri, header, e.Body, e.IsBase64Encoded, e.RequestContext.HTTP.SourceIP)
+}
+
+func (e *httpAPIEvent) response(w *responseWriter) (any, error) {
+	// Cookies are returned separately, and the rest of the headers joined
+	cookies := w.header.Values("Set-Cookie")
+	w.header.Del("Set-Cookie")
+
+	body, base64Encoded := w.encodedBody()
+	return events.APIGatewayV2HTTPResponse{
+		StatusCode:      w.statusCode(),
+		Headers:         singleValueHeaders(w.header),
+		Cookies:         cookies,
+		Body:            body,
+		IsBase64Encoded: base64Encoded,
+	}, nil
+}
+
+// restAPIEvent is an API Gateway REST API event, or an HTTP API one with
+// payload format 1.0.
+type restAPIEvent struct {
+	events.APIGatewayProxyRequest
+}
+
+func (e *restAPIEvent) request(ctx context.Context) (*http.Request, error) {
+	header := eventHeader(e.Headers, e.MultiValueHeaders)
+
+	// The path and query parameters are decoded
+	u := url.URL{Path: e.Path}
+	if len(e.MultiValueQueryStringParameters) > 0 {
+		u.RawQuery = url.Values(e.MultiValueQueryStringParameters).Encode()
+	} else if len(e.QueryStringParameters) > 0 {
+		query := url.Values{}
+		for name, value := range e.QueryStringParameters {
+			query.Set(name, value)
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	return newRequest(ctx, e.HTTPMethod, u.String(), header, e.Body, e.IsBase64Encoded, e.RequestContext.Identity.SourceIP)
+}
+
+func (e *restAPIEvent) response(w *responseWriter) (any, error) {
+	body, base64Encoded := w.encodedBody()
+	return events.APIGatewayProxyResponse{
+		StatusCode:        w.statusCode(),
+		MultiValueHeaders: w.header,
+		Body:              body,
+		IsBase64Encoded:   base64Encoded,
+	}, nil
+}
+
+// albEvent is an Application Load Balancer event.
+type albEvent struct {
+	events.ALBTargetGroupRequest
+}
+
+func (e *albEvent) request(ctx context.Context) (*http.Request, error) {
+	header := eventHeader(e.Headers, e.MultiValueHeaders)
+
+	// Unlike API Gateway, the query parameters are as they were sent, still
+	// encoded
+	var query []string
+	if len(e.MultiValueQueryStringParameters) > 0 {
+		for name, values := range e.MultiValueQueryStringParameters {
+			for _, value := range values {
+				query = append(query, name+"="+value)
+			}
+		}
+	} else {
+		for name, value := range e.QueryStringParameters {
+			query = append(query, name+"="+value)
+		}
+	}
+
+	uri := e.Path
+	if len(query) > 0 {
+		uri += "?" + strings.Join(query, "&")
+	}
+
+	// The load balancer's client IP is in X-Forwarded-For
+	return newRequest(ctx, e.HTTPMethod, uri, header, e.Body, e.IsBase64Encoded, "")
+}
+
+func (e *albEvent) response(w *responseWriter) (any, error) {
+	body, base64Encoded := w.encodedBody()
+	resp := events.ALBTargetGroupResponse{
+		StatusCode:        w.statusCode(),
+		StatusDescription: fmt.Sprintf("%d %s", w.statusCode(), http.StatusText(w.statusCode())),
+		Body:              body,
+		IsBase64Encoded:   base64Encoded,
+	}
+	// The response must have multi-value headers if the target group has them
+	// enabled, which is when the request does
+	if len(e.MultiValueHeaders) > 0 {
+		resp.MultiValueHeaders = w.header
+		return resp, nil
+	}
+
+	// Otherwise only one cookie can be set, and dropping the others would
+	// break logins in ways that are hard to track down
+	if values := w.header.Values("Set-Cookie"); len(values) > 0 {
+		cookies := lastCookies(values)
+		if len(cookies) > 1 {
+			return nil, fmt.Errorf("%w (%s %s sets %d)", ErrTooManyCookies, e.HTTPMethod, e.Path, len(cookies))
+		}
+		w.header["Set-Cookie"] = cookies
+	}
+	resp.Headers = singleValueHeaders(w.header)
+	return resp, nil
+}
+
+// eventHeader is the request header from an event's headers, using the
+// multi-value headers if it has them.
+func eventHeader(single map[string]string, multi map[string][]string) http.Header {
+	header := http.Header{}
+	if len(multi) > 0 {
+		for name, values := range multi {
+			for _, value := range values {
+				header.Add(name, value)
+			}
+		}
+		return header
+	}
+
+	for name, value := range single {
+		header.Set(name, value)
+	}
+	return header
+}
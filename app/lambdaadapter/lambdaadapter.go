@@ -0,0 +1,192 @@
+// Package lambdaadapter serves an http.Handler (the Echo app) as a Lambda
+// function, so it doesn't need the Lambda Web Adapter layer. It handles API
+// Gateway HTTP API (payload format 2.0), API Gateway REST API (and HTTP API
+// payload format 1.0) and Application Load Balancer events, turning each into
+// an http.Request, and the handler's response into the event's response.
+//
+//	lambda.Start(lambdaadapter.New(app))
+package lambdaadapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrTooManyCookies is returned for a response setting more than one cookie to
+// an Application Load Balancer without multi-value headers, which can only send
+// one. Enable multi-value headers on the target group to fix it.
+var ErrTooManyCookies = errors.New("lambdaadapter: the response sets more than one cookie, enable multi-value headers on the ALB target group")
+
+// Handler is a lambda.Handler for the http.Handler.
+type Handler struct {
+	handler http.Handler
+}
+
+// New returns a Lambda handler serving the events with the http.Handler.
+func New(handler http.Handler) *Handler {
+	return &Handler{handler: handler}
+}
+
+// eventKind is just enough of an event to tell which kind it is.
+type eventKind struct {
+	RequestContext struct {
+		HTTP json.RawMessage `json:"http"`
+		ELB  json.RawMessage `json:"elb"`
+	} `json:"requestContext"`
+}
+
+// Invoke handles an event. The request's context is the invocation's, so it's
+// cancelled when the function times out. It fails with ErrTooManyCookies if
+// the response can't be sent as it is.
+func (h *Handler) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	var kind eventKind
+	if err := json.Unmarshal(payload, &kind); err != nil {
+		return nil, fmt.Errorf("failed to parse event: %w", err)
+	}
+
+	var e event
+	switch {
+	case kind.RequestContext.HTTP != nil:
+		e = &httpAPIEvent{}
+	case kind.RequestContext.ELB != nil:
+		e = &albEvent{}
+	default:
+		e = &restAPIEvent{}
+	}
+	if err := json.Unmarshal(payload, e); err != nil {
+		return nil, fmt.Errorf("failed to parse event: %w", err)
+	}
+
+	req, err := e.request(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	w := newResponseWriter()
+	h.handler.ServeHTTP(w, req)
+
+	resp, err := e.response(w)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(resp)
+}
+
+// event is a kind of HTTP event.
+type event interface {
+	// request is the event's request.
+	request(ctx context.Context) (*http.Request, error)
+	// response is the event's response for what the handler wrote.
+	response(w *responseWriter) (any, error)
+}
+
+// newRequest makes the request from the parts of an event.
+func newRequest(ctx context.Context, method, uri string, header http.Header, body string, base64Encoded bool, sourceIP string) (*http.Request, error) {
+	bodyBytes := []byte(body)
+	if base64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode request body: %w", err)
+		}
+		bodyBytes = decoded
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, uri, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+
+	req.Header = header
+	req.Host = header.Get("Host")
+	req.URL.Host = req.Host
+	req.RequestURI = req.URL.RequestURI()
+	req.RemoteAddr = sourceIP
+	req.ContentLength = int64(len(bodyBytes))
+
+	return req, nil
+}
+
+// responseWriter keeps the response for the event's response.
+type responseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseWriter() *responseWriter {
+	return &responseWriter{header: http.Header{}}
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+// Flush does nothing, as the response is sent when the handler returns.
+func (w *responseWriter) Flush() {}
+
+func (w *responseWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// encodedBody is the body for the event's response, which is base64 encoded
+// unless it's uncompressed text.
+func (w *responseWriter) encodedBody() (string, bool) {
+	body := w.body.Bytes()
+	encoding := w.header.Get("Content-Encoding")
+	if (encoding == "" || encoding == "identity") && utf8.Valid(body) {
+		return string(body), false
+	}
+	return base64.StdEncoding.EncodeToString(body), true
+}
+
+// singleValueHeaders joins each header's values with commas, for responses that
+// only have one value per header. Cookies can't be joined, so the caller must
+// deal with them first.
+func singleValueHeaders(h http.Header) map[string]string {
+	headers := make(map[string]string, len(h))
+	for name, values := range h {
+		headers[name] = strings.Join(values, ",")
+	}
+	return headers
+}
+
+// lastCookies returns the Set-Cookie values, keeping only the last one for
+// each cookie name, as that's the one the browser ends up with. E.g. when the
+// session is regenerated, the old cookie is deleted and then set again.
+func lastCookies(values []string) []string {
+	var cookies []string
+	seen := map[string]bool{}
+	for _, value := range slices.Backward(values) {
+		name, _, _ := strings.Cut(value, "=")
+		name = strings.TrimSpace(name)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		cookies = append(cookies, value)
+	}
+	slices.Reverse(cookies)
+	return cookies
+}
@@ -0,0 +1,325 @@
+package lambdaadapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// binaryBody isn't valid UTF-8, so it has to be base64 encoded both ways.
+var binaryBody = []byte{0x00, 0xff, 0xfe, 'h', 'i'}
+
+// seenRequest is what the handler got.
+type seenRequest struct {
+	method     string
+	path       string
+	query      map[string][]string
+	host       string
+	remoteAddr string
+	cookies    []string
+	multi      []string
+	body       []byte
+}
+
+// testHandler records the request, and responds with the cookies named in the
+// set_cookie query parameter, a header with two values, and a binary body.
+func testHandler(seen *seenRequest) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var cookies []string
+		for _, c := range r.Cookies() {
+			cookies = append(cookies, c.Name+"="+c.Value)
+		}
+		*seen = seenRequest{
+			method:     r.Method,
+			path:       r.URL.Path,
+			query:      r.URL.Query(),
+			host:       r.Host,
+			remoteAddr: r.RemoteAddr,
+			cookies:    cookies,
+			multi:      r.Header.Values("X-Multi"),
+			body:       body,
+		}
+
+		for _, name := range r.URL.Query()["set_cookie"] {
+			http.SetCookie(w, &http.Cookie{Name: name, Value: "value-" + name})
+		}
+		w.Header().Add("X-Multi", "one")
+		w.Header().Add("X-Multi", "two")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(binaryBody)
+	})
+}
+
+// invoke invokes the handler with the event, and decodes the response into
+// resp.
+func invoke(t *testing.T, event string, resp any) seenRequest {
+	t.Helper()
+
+	var seen seenRequest
+	payload, err := New(testHandler(&seen)).Invoke(context.Background(), []byte(event))
+	if err != nil {
+		t.Fatalf("Invoke() error = %v", err)
+	}
+	if err := json.Unmarshal(payload, resp); err != nil {
+		t.Fatalf("failed to decode response %s: %v", payload, err)
+	}
+	return seen
+}
+
+var encodedBinaryBody = base64.StdEncoding.EncodeToString(binaryBody)
+
+// checkRequest checks the handler got the request in the fixtures.
+func checkRequest(t *testing.T, seen seenRequest) {
+	t.Helper()
+
+	if seen.method != http.MethodPost || seen.path != "/things" || seen.host != "app.example.com" {
+		t.Errorf("request = %s %s on %s, want POST /things on app.example.com", seen.method, seen.path, seen.host)
+	}
+	if q := seen.query["q"]; !slices.Equal(q, []string{"a b", "c"}) {
+		t.Errorf("query q = %q, want [a b c]", q)
+	}
+	if !slices.Equal(seen.cookies, []string{"session=abc", "_csrf=def"}) {
+		t.Errorf("cookies = %q, want session and _csrf", seen.cookies)
+	}
+	if !bytes.Equal(seen.body, binaryBody) {
+		t.Errorf("body = %v, want %v", seen.body, binaryBody)
+	}
+}
+
+func checkBody(t *testing.T, body string, base64Encoded bool) {
+	t.Helper()
+
+	if !base64Encoded || body != encodedBinaryBody {
+		t.Errorf("body = %q (base64 %t), want %q base64 encoded", body, base64Encoded, encodedBinaryBody)
+	}
+}
+
+func TestHTTPAPIEvent(t *testing.T) {
+	event := `{
+		"version": "2.0",
+		"routeKey": "$default",
+		"rawPath": "/things",
+		"rawQueryString": "q=a%20b&q=c&set_cookie=session&set_cookie=_csrf",
+		"cookies": ["session=abc", "_csrf=def"],
+		"headers": {
+			"host": "app.example.com",
+			"x-multi": "one,two",
+			"content-type": "application/octet-stream"
+		},
+		"requestContext": {
+			"http": {"method": "POST", "path": "/things", "sourceIp": "192.0.2.1"}
+		},
+		"body": "` + encodedBinaryBody + `",
+		"isBase64Encoded": true
+	}`
+
+	var resp events.APIGatewayV2HTTPResponse
+	seen := invoke(t, event, &resp)
+
+	checkRequest(t, seen)
+	if seen.remoteAddr != "192.0.2.1" {
+		t.Errorf("RemoteAddr = %q, want the source IP", seen.remoteAddr)
+	}
+	if !slices.Equal(seen.multi, []string{"one,two"}) {
+		t.Errorf("X-Multi = %q, want the joined header", seen.multi)
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("StatusCode = %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	if !slices.Equal(resp.Cookies, []string{"session=value-session", "_csrf=value-_csrf"}) {
+		t.Errorf("Cookies = %q, want both cookies", resp.Cookies)
+	}
+	if _, ok := resp.Headers["Set-Cookie"]; ok {
+		t.Error("Set-Cookie is in the headers as well as the cookies")
+	}
+	if resp.Headers["X-Multi"] != "one,two" {
+		t.Errorf("X-Multi = %q, want one,two", resp.Headers["X-Multi"])
+	}
+	checkBody(t, resp.Body, resp.IsBase64Encoded)
+}
+
+func TestRESTAPIEvent(t *testing.T) {
+	event := `{
+		"resource": "/{proxy+}",
+		"path": "/things",
+		"httpMethod": "POST",
+		"headers": {"Host": "app.example.com", "X-Multi": "two"},
+		"multiValueHeaders": {
+			"Host": ["app.example.com"],
+			"Cookie": ["session=abc; _csrf=def"],
+			"X-Multi": ["one", "two"]
+		},
+		"queryStringParameters": {"q": "c", "set_cookie": "_csrf"},
+		"multiValueQueryStringParameters": {"q": ["a b", "c"], "set_cookie": ["session", "_csrf"]},
+		"requestContext": {"identity": {"sourceIp": "192.0.2.1"}},
+		"body": "` + encodedBinaryBody + `",
+		"isBase64Encoded": true
+	}`
+
+	var resp events.APIGatewayProxyResponse
+	seen := invoke(t, event, &resp)
+
+	checkRequest(t, seen)
+	if seen.remoteAddr != "192.0.2.1" {
+		t.Errorf("RemoteAddr = %q, want the source IP", seen.remoteAddr)
+	}
+	if !slices.Equal(seen.multi, []string{"one", "two"}) {
+		t.Errorf("X-Multi = %q, want both values", seen.multi)
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("StatusCode = %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	if cookies := resp.MultiValueHeaders["Set-Cookie"]; !slices.Equal(cookies, []string{"session=value-session", "_csrf=value-_csrf"}) {
+		t.Errorf("Set-Cookie = %q, want both cookies", cookies)
+	}
+	if multi := resp.MultiValueHeaders["X-Multi"]; !slices.Equal(multi, []string{"one", "two"}) {
+		t.Errorf("X-Multi = %q, want both values", multi)
+	}
+	checkBody(t, resp.Body, resp.IsBase64Encoded)
+}
+
+func TestALBEventMultiValueHeaders(t *testing.T) {
+	event := `{
+		"requestContext": {"elb": {"targetGroupArn": "arn:aws:elasticloadbalancing:eu-west-2:123456789012:targetgroup/app/abc"}},
+		"httpMethod": "POST",
+		"path": "/things",
+		"multiValueQueryStringParameters": {"q": ["a%20b", "c"], "set_cookie": ["session", "_csrf"]},
+		"multiValueHeaders": {
+			"host": ["app.example.com"],
+			"cookie": ["session=abc; _csrf=def"],
+			"x-multi": ["one", "two"],
+			"x-forwarded-for": ["192.0.2.1"]
+		},
+		"body": "` + encodedBinaryBody + `",
+		"isBase64Encoded": true
+	}`
+
+	var resp events.ALBTargetGroupResponse
+	seen := invoke(t, event, &resp)
+
+	checkRequest(t, seen)
+	if !slices.Equal(seen.multi, []string{"one", "two"}) {
+		t.Errorf("X-Multi = %q, want both values", seen.multi)
+	}
+
+	if resp.StatusCode != http.StatusCreated || resp.StatusDescription != "201 Created" {
+		t.Errorf("status = %d %q, want 201 Created", resp.StatusCode, resp.StatusDescription)
+	}
+	if len(resp.Headers) != 0 {
+		t.Errorf("Headers = %v, want only multi-value headers", resp.Headers)
+	}
+	if cookies := resp.MultiValueHeaders["Set-Cookie"]; !slices.Equal(cookies, []string{"session=value-session", "_csrf=value-_csrf"}) {
+		t.Errorf("Set-Cookie = %q, want both cookies", cookies)
+	}
+	checkBody(t, resp.Body, resp.IsBase64Encoded)
+}
+
+// singleValueALBEvent returns a single-value header ALB event for the query.
+func singleValueALBEvent(query string) string {
+	return `{
+		"requestContext": {"elb": {"targetGroupArn": "arn:aws:elasticloadbalancing:eu-west-2:123456789012:targetgroup/app/abc"}},
+		"httpMethod": "GET",
+		"path": "/things",
+		"queryStringParameters": ` + query + `,
+		"headers": {"host": "app.example.com", "cookie": "session=abc; _csrf=def"},
+		"body": "",
+		"isBase64Encoded": false
+	}`
+}
+
+func TestALBEventSingleValueHeaders(t *testing.T) {
+	var resp events.ALBTargetGroupResponse
+	seen := invoke(t, singleValueALBEvent(`{"q": "a%20b", "set_cookie": "session"}`), &resp)
+
+	if q := seen.query["q"]; !slices.Equal(q, []string{"a b"}) {
+		t.Errorf("query q = %q, want [a b]", q)
+	}
+	if !slices.Equal(seen.cookies, []string{"session=abc", "_csrf=def"}) {
+		t.Errorf("cookies = %q, want session and _csrf", seen.cookies)
+	}
+
+	if len(resp.MultiValueHeaders) != 0 {
+		t.Errorf("MultiValueHeaders = %v, want only single-value headers", resp.MultiValueHeaders)
+	}
+	if resp.Headers["Set-Cookie"] != "session=value-session" {
+		t.Errorf("Set-Cookie = %q, want the session cookie", resp.Headers["Set-Cookie"])
+	}
+	if resp.Headers["X-Multi"] != "one,two" {
+		t.Errorf("X-Multi = %q, want one,two", resp.Headers["X-Multi"])
+	}
+	checkBody(t, resp.Body, resp.IsBase64Encoded)
+}
+
+func TestALBEventSingleValueHeadersCookies(t *testing.T) {
+	handler := func(cookies ...*http.Cookie) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, c := range cookies {
+				http.SetCookie(w, c)
+			}
+		})
+	}
+
+	tests := []struct {
+		name    string
+		cookies []*http.Cookie
+		want    string
+		wantErr error
+	}{
+		{"none", nil, "", nil},
+		{"one", []*http.Cookie{{Name: "session", Value: "new"}}, "session=new", nil},
+		// e.g. regenerating the session
+		{"same cookie twice", []*http.Cookie{{Name: "session", MaxAge: -1}, {Name: "session", Value: "new"}}, "session=new", nil},
+		{"two cookies", []*http.Cookie{{Name: "session", Value: "new"}, {Name: "_csrf", Value: "token"}}, "", ErrTooManyCookies},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := New(handler(tt.cookies...)).Invoke(context.Background(), []byte(singleValueALBEvent(`{}`)))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Invoke() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			var resp events.ALBTargetGroupResponse
+			if err := json.Unmarshal(payload, &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Headers["Set-Cookie"] != tt.want {
+				t.Errorf("Set-Cookie = %q, want %q", resp.Headers["Set-Cookie"], tt.want)
+			}
+		})
+	}
+}
+
+func TestTextResponseBody(t *testing.T) {
+	var resp events.APIGatewayV2HTTPResponse
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<p>héllo</p>"))
+	})
+	event := `{"version": "2.0", "rawPath": "/", "headers": {"host": "app.example.com"}, "requestContext": {"http": {"method": "GET"}}}`
+
+	payload, err := New(handler).Invoke(context.Background(), []byte(event))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.IsBase64Encoded || resp.Body != "<p>héllo</p>" || resp.StatusCode != http.StatusOK {
+		t.Errorf("response = %d %q (base64 %t), want 200 with the text as it is", resp.StatusCode, resp.Body, resp.IsBase64Encoded)
+	}
+}
@@ -0,0 +1,542 @@
+package mockcognito
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	pool := "/" + s.opts.PoolID
+	mux.HandleFunc("GET "+pool+"/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("GET "+pool+"/.well-known/jwks.json", s.jwks)
+
+	mux.HandleFunc("GET "+PathAuthorize, s.authorize)
+	mux.HandleFunc("GET "+PathLogin, s.loginPage)
+	mux.HandleFunc("POST "+PathLogin, s.login)
+	mux.HandleFunc("POST "+PathToken, s.token)
+	mux.HandleFunc("GET "+PathUserInfo, s.userInfo)
+	mux.HandleFunc("POST "+PathRevoke, s.revoke)
+	mux.HandleFunc("GET "+PathLogout, s.logout)
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeOAuthError writes an OAuth error response, e.g. {"error":"invalid_grant"}.
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	body := map[string]string{"error": code}
+	if description != "" {
+		body["error_description"] = description
+	}
+	writeJSON(w, status, body)
+}
+
+// fail responds with the next failure queued for the endpoint, if there is one.
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, path string) bool {
+	f, ok := s.nextFailure(path)
+	if !ok {
+		return false
+	}
+
+	if f.Delay > 0 {
+		// The request is only cancelled when the client goes away once its
+		// body has been read
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-time.After(f.Delay):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+
+	if f.Code == "" {
+		http.Error(w, f.Description, f.Status)
+	} else {
+		writeOAuthError(w, f.Status, f.Code, f.Description)
+	}
+	return true
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.URL + PathAuthorize,
+		"token_endpoint":                        s.URL + PathToken,
+		"userinfo_endpoint":                     s.URL + PathUserInfo,
+		"jwks_uri":                              s.issuer + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code", "token"},
+		"scopes_supported":                      []string{"openid", "email", "phone", "profile"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kid": keyID,
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// authRequest is a validated authorization request, from the query of the
+// authorize and login endpoints.
+type authRequest struct {
+	redirectURI   string
+	state         string
+	codeChallenge string
+	nonce         string
+	scope         string
+}
+
+// errRedirect is an authorization request error that is sent back to the app,
+// rather than shown to the user.
+type errRedirect struct {
+	code, description string
+}
+
+func (e *errRedirect) Error() string {
+	return e.code + ": " + e.description
+}
+
+// parseAuthRequest validates the authorization request. Errors with the client
+// or redirect URI are shown to the user (an *errRedirect can't be sent to a
+// URL we don't trust), others are returned as an *errRedirect.
+func (s *Server) parseAuthRequest(q url.Values) (authRequest, error) {
+	req := authRequest{
+		redirectURI:   q.Get("redirect_uri"),
+		state:         q.Get("state"),
+		codeChallenge: q.Get("code_challenge"),
+		nonce:         q.Get("nonce"),
+		scope:         q.Get("scope"),
+	}
+
+	if q.Get("client_id") != s.ClientID {
+		return req, errors.New("invalid client_id")
+	}
+	if req.redirectURI == "" || !allowedURL(s.opts.CallbackURLs, req.redirectURI) {
+		return req, errors.New("redirect_mismatch")
+	}
+	if q.Get("response_type") != "code" {
+		return req, &errRedirect{"unsupported_response_type", "only the code response type is supported"}
+	}
+	if req.codeChallenge != "" && q.Get("code_challenge_method") != "S256" {
+		return req, &errRedirect{"invalid_request", "code_challenge_method must be S256"}
+	}
+
+	return req, nil
+}
+
+// redirectError sends the user back to the app with the error.
+func redirectError(w http.ResponseWriter, r *http.Request, req authRequest, code, description string) {
+	params := url.Values{"error": {code}, "state": {req.state}}
+	if description != "" {
+		params.Set("error_description", description)
+	}
+	http.Redirect(w, r, withQuery(req.redirectURI, params), http.StatusFound)
+}
+
+// handleAuthRequestError responds to an invalid authorization request.
+func handleAuthRequestError(w http.ResponseWriter, r *http.Request, req authRequest, err error) {
+	var redirect *errRedirect
+	if errors.As(err, &redirect) {
+		redirectError(w, r, req, redirect.code, redirect.description)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// failAuthRequest sends the next failure queued for the endpoint (if any) back
+// to the app.
+func (s *Server) failAuthRequest(w http.ResponseWriter, r *http.Request, path string, req authRequest) bool {
+	f, ok := s.nextFailure(path)
+	if !ok {
+		return false
+	}
+
+	redirectError(w, r, req, f.Code, f.Description)
+	return true
+}
+
+// authorize starts a login. Users already logged in to the managed login pages
+// go straight back to the app, others are sent to the login page.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	req, err := s.parseAuthRequest(r.URL.Query())
+	if err != nil {
+		handleAuthRequestError(w, r, req, err)
+		return
+	}
+	if s.failAuthRequest(w, r, PathAuthorize, req) {
+		return
+	}
+
+	if session := s.loginSession(r); session != nil {
+		s.redirectWithCode(w, r, req, session)
+		return
+	}
+
+	http.Redirect(w, r, s.URL+PathLogin+"?"+r.URL.RawQuery, http.StatusFound)
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><title>Sign in</title></head>
+<body>
+<h1>Sign in</h1>
+{{if .Error}}<p id="error">{{.Error}}</p>{{end}}
+<form method="post" action="{{.Action}}">
+<label>Username <input name="username" value="{{.Username}}"></label>
+<label>Password <input name="password" type="password"></label>
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+func (s *Server) renderLogin(w http.ResponseWriter, r *http.Request, username, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	loginTemplate.Execute(w, map[string]string{
+		"Action":   PathLogin + "?" + r.URL.RawQuery,
+		"Username": username,
+		"Error":    message,
+	})
+}
+
+// loginPage shows the login form.
+func (s *Server) loginPage(w http.ResponseWriter, r *http.Request) {
+	req, err := s.parseAuthRequest(r.URL.Query())
+	if err != nil {
+		handleAuthRequestError(w, r, req, err)
+		return
+	}
+
+	if session := s.loginSession(r); session != nil {
+		s.redirectWithCode(w, r, req, session)
+		return
+	}
+
+	s.renderLogin(w, r, "", "")
+}
+
+// login checks the username and password posted from the login form, and sends
+// the user back to the app with an authorization code.
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	req, err := s.parseAuthRequest(r.URL.Query())
+	if err != nil {
+		handleAuthRequestError(w, r, req, err)
+		return
+	}
+	if s.failAuthRequest(w, r, PathLogin, req) {
+		return
+	}
+
+	username, password := r.PostFormValue("username"), r.PostFormValue("password")
+
+	s.mu.Lock()
+	user, ok := s.users[username]
+	ok = ok && user.Password == password
+	var session *loginSession
+	if ok {
+		session = &loginSession{id: rand.Text(), username: username}
+		s.sessions[session.id] = session
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		s.renderLogin(w, r, username, "Incorrect username or password.")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: session.id, Path: "/", HttpOnly: true})
+	s.redirectWithCode(w, r, req, session)
+}
+
+// loginSession returns the request's managed login session, if it has one.
+func (s *Server) loginSession(r *http.Request) *loginSession {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[c.Value]
+	if !ok || s.users[session.username] == nil {
+		return nil
+	}
+	return session
+}
+
+// redirectWithCode sends the user back to the app with a new authorization
+// code.
+func (s *Server) redirectWithCode(w http.ResponseWriter, r *http.Request, req authRequest, session *loginSession) {
+	code := rand.Text()
+
+	s.mu.Lock()
+	s.codes[code] = &authCode{
+		username:      session.username,
+		redirectURI:   req.redirectURI,
+		codeChallenge: req.codeChallenge,
+		nonce:         req.nonce,
+		scope:         req.scope,
+		sessionID:     session.id,
+		expiresAt:     time.Now().Add(codeTTL),
+	}
+	s.mu.Unlock()
+
+	http.Redirect(w, r, withQuery(req.redirectURI, url.Values{"code": {code}, "state": {req.state}}), http.StatusFound)
+}
+
+// authenticateClient checks the client ID, and the secret if the client has
+// one, from basic auth or the form.
+func (s *Server) authenticateClient(r *http.Request) bool {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+
+	return clientID == s.ClientID && secret == s.ClientSecret
+}
+
+// token exchanges an authorization code or refresh token for tokens.
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if s.fail(w, r, PathToken) {
+		return
+	}
+
+	if !s.authenticateClient(r) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_client", "")
+		return
+	}
+
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		s.exchangeCode(w, r)
+	case "refresh_token":
+		s.refresh(w, r)
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+}
+
+func (s *Server) exchangeCode(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Codes are single use, even if the exchange fails
+	code, ok := s.codes[r.PostFormValue("code")]
+	delete(s.codes, r.PostFormValue("code"))
+
+	switch {
+	case !ok, time.Now().After(code.expiresAt), code.redirectURI != r.PostFormValue("redirect_uri"):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "")
+		return
+	case code.codeChallenge != "" && s256(r.PostFormValue("code_verifier")) != code.codeChallenge:
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+
+	user, ok := s.users[code.username]
+	if !ok {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+
+	refreshToken := rand.Text()
+	for len(refreshToken) < s.opts.RefreshTokenSize {
+		refreshToken += rand.Text()
+	}
+	grant := &refreshGrant{username: user.Username, scope: code.scope, sessionID: code.sessionID}
+	s.refreshTokens[refreshToken] = grant
+	s.writeTokens(w, user, grant, code.nonce, refreshToken)
+}
+
+// refresh issues new access and ID tokens. Like Cognito (without refresh token
+// rotation), it doesn't issue a new refresh token.
+func (s *Server) refresh(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grant, ok := s.refreshTokens[r.PostFormValue("refresh_token")]
+	if !ok {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+
+	user, ok := s.users[grant.username]
+	if !ok {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+
+	s.writeTokens(w, user, grant, "", "")
+}
+
+// writeTokens issues access and ID tokens for the grant. The caller must hold
+// the lock.
+func (s *Server) writeTokens(w http.ResponseWriter, user *User, grant *refreshGrant, nonce, refreshToken string) {
+	now := time.Now()
+	exp := now.Add(s.opts.AccessTokenTTL)
+
+	idClaims := jwt.MapClaims{
+		"iss":              s.issuer,
+		"aud":              s.ClientID,
+		"sub":              user.ID,
+		"token_use":        "id",
+		"auth_time":        now.Unix(),
+		"iat":              now.Unix(),
+		"exp":              exp.Unix(),
+		"jti":              rand.Text(),
+		"sid":              grant.sessionID,
+		"cognito:username": user.Username,
+		"name":             user.Name,
+		"email":            user.Email,
+		"email_verified":   user.Email != "",
+	}
+	if nonce != "" {
+		idClaims["nonce"] = nonce
+	}
+	if len(user.Groups) > 0 {
+		idClaims["cognito:groups"] = user.Groups
+	}
+
+	accessToken := s.signAccessToken(user, grant.scope, now, exp)
+	s.accessTokens[accessToken] = user.Username
+	grant.accessTokens = append(grant.accessTokens, accessToken)
+
+	body := map[string]any{
+		"access_token": accessToken,
+		"id_token":     s.sign(idClaims),
+		"token_type":   "Bearer",
+		"expires_in":   int(s.opts.AccessTokenTTL.Seconds()),
+	}
+	if refreshToken != "" {
+		body["refresh_token"] = refreshToken
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (s *Server) signAccessToken(user *User, scope string, now, exp time.Time) string {
+	claims := jwt.MapClaims{
+		"iss":       s.issuer,
+		"sub":       user.ID,
+		"token_use": "access",
+		"client_id": s.ClientID,
+		"scope":     scope,
+		"username":  user.Username,
+		"auth_time": now.Unix(),
+		"iat":       now.Unix(),
+		"exp":       exp.Unix(),
+		"jti":       rand.Text(),
+	}
+	if len(user.Groups) > 0 {
+		claims["cognito:groups"] = user.Groups
+	}
+
+	return s.sign(claims)
+}
+
+// userInfo returns the user for the bearer access token.
+func (s *Server) userInfo(w http.ResponseWriter, r *http.Request) {
+	if s.fail(w, r, PathUserInfo) {
+		return
+	}
+
+	accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mu.Lock()
+	var user *User
+	if ok {
+		user = s.users[s.accessTokens[accessToken]]
+	}
+	s.mu.Unlock()
+
+	if user == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "Access token is expired, disabled, or deleted, or the user has globally signed out.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sub":            user.ID,
+		"username":       user.Username,
+		"name":           user.Name,
+		"email":          user.Email,
+		"email_verified": "true",
+	})
+}
+
+// revoke revokes a refresh token, and the access tokens issued with it.
+// Unknown tokens are ignored, as RFC 7009 requires.
+func (s *Server) revoke(w http.ResponseWriter, r *http.Request) {
+	if s.fail(w, r, PathRevoke) {
+		return
+	}
+
+	if !s.authenticateClient(r) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_client", "")
+		return
+	}
+
+	s.mu.Lock()
+	token := r.PostFormValue("token")
+	if grant, ok := s.refreshTokens[token]; ok {
+		for _, accessToken := range grant.accessTokens {
+			delete(s.accessTokens, accessToken)
+		}
+		delete(s.refreshTokens, token)
+	}
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// logout ends the managed login session, and sends the user to the app's
+// logout URL.
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	if s.fail(w, r, PathLogout) {
+		return
+	}
+
+	q := r.URL.Query()
+	logoutURI := q.Get("logout_uri")
+	if q.Get("client_id") != s.ClientID || logoutURI == "" || !allowedURL(s.opts.LogoutURLs, logoutURI) {
+		http.Error(w, "invalid logout request", http.StatusBadRequest)
+		return
+	}
+
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		s.mu.Lock()
+		delete(s.sessions, c.Value)
+		s.mu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+
+	http.Redirect(w, r, logoutURI, http.StatusFound)
+}
@@ -0,0 +1,360 @@
+// Package mockcognito is a fake Cognito user pool and app client, served by an
+// httptest.Server on localhost, for running the login flow without a real pool
+// (e.g. in end-to-end tests). It implements the managed login page and the
+// OAuth/OIDC endpoints cognitoauth uses: /login, /oauth2/authorize,
+// /oauth2/token, /oauth2/userInfo, /oauth2/revoke and /logout, plus the
+// issuer's discovery document and JWKS. Like Cognito, the discovery document
+// doesn't list the revocation and logout endpoints, so the app needs the
+// server's URL as its BaseURL too.
+//
+// Users, their groups and passwords are set up in the Options, and can be
+// changed while the server runs. FailNext makes an endpoint fail, to test how
+// the app handles errors.
+package mockcognito
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	DefaultClientID       = "mock-client-id"
+	DefaultPoolID         = "local_mockpool"
+	DefaultAccessTokenTTL = time.Hour
+
+	// Paths of the endpoints, for FailNext.
+	PathLogin     = "/login"
+	PathAuthorize = "/oauth2/authorize"
+	PathToken     = "/oauth2/token"
+	PathUserInfo  = "/oauth2/userInfo"
+	PathRevoke    = "/oauth2/revoke"
+	PathLogout    = "/logout"
+
+	// codeTTL is how long an authorization code can be exchanged for, like
+	// Cognito's 5 minutes.
+	codeTTL = 5 * time.Minute
+
+	// sessionCookie is the name of the cookie for the managed login session,
+	// which lets users log in again without their password until they log out.
+	sessionCookie = "cognito"
+
+	keyID = "mockcognito-1"
+)
+
+// User is a user in the pool.
+type User struct {
+	// ID is the user's sub. Generated if not set.
+	ID       string
+	Username string
+	Password string
+	Name     string
+	Email    string
+	// Groups are the user's Cognito groups, i.e. their roles in the app.
+	Groups []string
+}
+
+// Options configure the server.
+type Options struct {
+	// ClientID defaults to DefaultClientID. If ClientSecret is set, the token
+	// and revocation endpoints require it.
+	ClientID     string
+	ClientSecret string
+	// PoolID is the last part of the issuer URL. Defaults to DefaultPoolID.
+	PoolID string
+	// CallbackURLs and LogoutURLs are the app client's allowed URLs. Any URL is
+	// allowed if they're empty.
+	CallbackURLs []string
+	LogoutURLs   []string
+	// AccessTokenTTL is how long the access and ID tokens last. Defaults to
+	// DefaultAccessTokenTTL.
+	AccessTokenTTL time.Duration
+	// RefreshTokenSize pads refresh tokens to at least this many bytes. Cognito's are
+	// encrypted JWTs of about 1.8KB, where by default they're short.
+	RefreshTokenSize int
+
+	Users []User
+}
+
+// Failure is what an endpoint returns instead of handling a request, see
+// FailNext.
+type Failure struct {
+	// Status is the HTTP status. Defaults to 400. Ignored for the
+	// authorization and login endpoints, which redirect back to the app with
+	// the error instead, as Cognito does.
+	Status int
+	// Code is the OAuth error code, e.g. "invalid_grant". If empty, the body
+	// is just the Description, like a load balancer or proxy error.
+	Code        string
+	Description string
+	// Delay holds the response back this long first, like a slow Cognito.
+	Delay time.Duration
+}
+
+// Server is the fake Cognito. Its methods are safe to call while it handles
+// requests.
+type Server struct {
+	// URL is the base URL, http://localhost:<port>, which serves both the user
+	// pool domain and the issuer.
+	URL          string
+	ClientID     string
+	ClientSecret string
+
+	opts   Options
+	issuer string
+	key    *rsa.PrivateKey
+	srv    *httptest.Server
+
+	mu            sync.Mutex
+	users         map[string]*User // by username
+	codes         map[string]*authCode
+	refreshTokens map[string]*refreshGrant
+	accessTokens  map[string]string // to the username
+	sessions      map[string]*loginSession
+	failures      map[string][]Failure
+}
+
+// authCode is an issued authorization code.
+type authCode struct {
+	username      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	scope         string
+	sessionID     string
+	expiresAt     time.Time
+}
+
+// refreshGrant is what a refresh token was issued for.
+type refreshGrant struct {
+	username     string
+	scope        string
+	sessionID    string
+	accessTokens []string
+}
+
+// loginSession is a user logged in to the managed login pages.
+type loginSession struct {
+	id       string
+	username string
+}
+
+// New starts the server. Like httptest.NewServer, it panics if it can't, and
+// must be closed.
+func New(opts Options) *Server {
+	if opts.ClientID == "" {
+		opts.ClientID = DefaultClientID
+	}
+	if opts.PoolID == "" {
+		opts.PoolID = DefaultPoolID
+	}
+	if opts.AccessTokenTTL == 0 {
+		opts.AccessTokenTTL = DefaultAccessTokenTTL
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic("mockcognito: failed to generate signing key: " + err.Error())
+	}
+
+	s := &Server{
+		ClientID:      opts.ClientID,
+		ClientSecret:  opts.ClientSecret,
+		opts:          opts,
+		key:           key,
+		users:         map[string]*User{},
+		codes:         map[string]*authCode{},
+		refreshTokens: map[string]*refreshGrant{},
+		accessTokens:  map[string]string{},
+		sessions:      map[string]*loginSession{},
+		failures:      map[string][]Failure{},
+	}
+	for _, u := range opts.Users {
+		s.AddUser(u)
+	}
+
+	// Listen on localhost, as the app only allows http (rather than https)
+	// Cognito URLs there
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		panic("mockcognito: failed to listen: " + err.Error())
+	}
+	s.srv = httptest.NewUnstartedServer(s.routes())
+	s.srv.Listener.Close()
+	s.srv.Listener = listener
+	s.srv.Start()
+
+	s.URL = "http://localhost:" + portOf(listener.Addr())
+	s.issuer = s.URL + "/" + opts.PoolID
+
+	return s
+}
+
+func portOf(addr net.Addr) string {
+	_, port, _ := net.SplitHostPort(addr.String())
+	return port
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// IssuerURL is the user pool's issuer, the app's IssuerURL.
+func (s *Server) IssuerURL() string {
+	return s.issuer
+}
+
+// AddUser adds the user to the pool (replacing any with the same username),
+// and returns it with its ID.
+func (s *Server) AddUser(u User) User {
+	if u.ID == "" {
+		u.ID = newUUID()
+	}
+	u.Groups = slices.Clone(u.Groups)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.Username] = &u
+
+	return u
+}
+
+// RemoveUser deletes the user, so their refresh tokens stop working.
+func (s *Server) RemoveUser(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users, username)
+}
+
+// SetGroups changes the user's groups, which the app sees the next time it
+// refreshes their tokens.
+func (s *Server) SetGroups(username string, groups ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[username]; ok {
+		u.Groups = slices.Clone(groups)
+	}
+}
+
+// SessionIDs returns the IDs of the user's sessions on the managed login pages,
+// which are the sid claim in the ID tokens for them.
+func (s *Server) SessionIDs(username string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []string
+	for _, session := range s.sessions {
+		if session.username == username {
+			ids = append(ids, session.id)
+		}
+	}
+	return ids
+}
+
+// IssueAccessToken issues an access token for the user with the scopes, as if
+// they'd logged in to the app client, e.g. to call an API in tests.
+func (s *Server) IssueAccessToken(username string, scopes ...string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return "", fmt.Errorf("mockcognito: no user %q", username)
+	}
+
+	now := time.Now()
+	accessToken := s.signAccessToken(user, strings.Join(scopes, " "), now, now.Add(s.opts.AccessTokenTTL))
+	s.accessTokens[accessToken] = user.Username
+
+	return accessToken, nil
+}
+
+// FailNext makes the next request to the endpoint (one of the Path constants)
+// fail with f. Calling it again queues more failures, e.g. to fail every retry.
+func (s *Server) FailNext(path string, f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[path] = append(s.failures[path], f)
+}
+
+// nextFailure removes and returns the next failure queued for the path.
+func (s *Server) nextFailure(path string) (Failure, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queued := s.failures[path]
+	if len(queued) == 0 {
+		return Failure{}, false
+	}
+	s.failures[path] = queued[1:]
+
+	f := queued[0]
+	if f.Status == 0 {
+		f.Status = http.StatusBadRequest
+	}
+	return f, true
+}
+
+// allowedURL reports whether the URL is one of the allowed ones (or any are).
+func allowedURL(allowed []string, u string) bool {
+	return len(allowed) == 0 || slices.Contains(allowed, u)
+}
+
+// withQuery adds the params to the URL's query.
+func withQuery(base string, params url.Values) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return base
+	}
+
+	q := u.Query()
+	for name, values := range params {
+		q[name] = values
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// s256 is the PKCE S256 code challenge for the verifier.
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// newUUID returns a random (version 4) UUID, like Cognito's user IDs.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// sign signs the claims with the server's key.
+func (s *Server) sign(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		panic("mockcognito: failed to sign token: " + err.Error())
+	}
+	return signed
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Session is one of a user's logged in sessions, e.g. in a particular browser.
+type Session struct {
+	// Handle identifies the session for revoking it. It isn't the session ID,
+	// which must stay secret.
+	Handle     string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	IP         string
+	UserAgent  string
+	// Current is set for the session of the request
+	Current bool
+}
@@ -1,6 +1,20 @@
 package models
 
+import "slices"
+
 type User struct {
-	ID   string
-	Name string
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Groups are the user's Cognito user pool groups, which we use as roles
+	Groups []string `json:"groups"`
+}
+
+// HasRole reports whether the user is in the Cognito group for the role.
+func (u *User) HasRole(role string) bool {
+	return slices.Contains(u.Groups, role)
+}
+
+// HasAnyRole reports whether the user has at least one of the roles.
+func (u *User) HasAnyRole(roles ...string) bool {
+	return slices.ContainsFunc(roles, u.HasRole)
 }
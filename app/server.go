@@ -2,201 +2,100 @@
 package main
 
 import (
+	"context"
 	"embed"
-	"encoding/gob"
 	"io/fs"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
-	"strings"
+	"os/signal"
+	"slices"
+	"syscall"
 
-	"github.com/a-h/templ"
-	"github.com/gorilla/sessions"
-	"github.com/labstack/echo-contrib/session"
-	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
-	slogecho "github.com/samber/slog-echo"
+	"github.com/aws/aws-lambda-go/lambda"
 
-	"echo-cognito-auth/models"
-	"echo-cognito-auth/views"
+	"echo-cognito-auth/config"
+	"echo-cognito-auth/lambdaadapter"
+	"echo-cognito-auth/server"
 )
 
-const (
-	sessionName    = "session"
-	sessionUserKey = "user"
-	contextUserKey = "user"
-)
+var (
+	LambdaStage = config.StageDev // gets set via go build ldflags -X option
+	GitCommit   = "unknown"       // likewise
+	BuildTime   = "unknown"       // likewise
+
+	logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{ReplaceAttr: redactQuery}))
 
-var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	// redactedQueryParams are kept out of the request logs, as the login
+	// callback's authorization code and state must stay secret.
+	redactedQueryParams = []string{"code", "state"}
+)
 
 //go:embed assets
 var staticAssets embed.FS
 
-type CustomContext struct {
-	echo.Context
-}
-
-func (c *CustomContext) User() *models.User {
-	user := c.Get(contextUserKey)
-	if user != nil {
-		return user.(*models.User)
-	}
-	return nil
-}
-
 func main() {
-	app := echo.New()
-
-	setupMiddleware(app)
-	setupRoutes(app)
-
-	// Use port 8080 as this is the default port for AWS Lambda Web Adapter
-	app.Logger.Fatal(app.Start(":8080"))
-}
-
-func setupMiddleware(e *echo.Echo) {
-	e.Use(slogecho.New(logger))
-	e.Use(middleware.Recover())
-
-	// session middleware & register custom types stored in session
-	store := sessions.NewCookieStore([]byte(os.Getenv("ECHO_COGNITO_AUTH_SESSION_SECRET")))
-	e.Use(session.Middleware(store))
-
-	gob.Register(models.User{})
-
-	// This needs the session, so needs to be after session middleware
-	e.Use(AddUserToContext)
-}
-
-func setupRoutes(e *echo.Echo) {
-	useOS := len(os.Args) > 1 && os.Args[1] == "live"
-	assetHandler := http.FileServer(getFileSystem(useOS))
-	e.GET("/assets/*", echo.WrapHandler(http.StripPrefix("/assets/", assetHandler)))
-
-	e.GET("/", HomeHandler)
-	e.GET("/login", LoginHandler)
-	e.GET("/auth/cognito/callback", CognitoCallbackHandler)
-	e.GET("/logout", LogoutHandler)
-
-	// Protected routes
-	adminGroup := e.Group("/admin", RequireAuth)
-	adminGroup.GET("", AdminHandler)
-
-	userGroup := e.Group("/user", RequireAuth)
-	userGroup.GET("", UserHandler)
-}
-
-// This custom Render replaces Echo's echo.Context.Render() with templ's templ.Component.Render().
-func Render(ctx echo.Context, statusCode int, t templ.Component) error {
-	buf := templ.GetBuffer()
-	defer templ.ReleaseBuffer(buf)
-
-	if err := t.Render(ctx.Request().Context(), buf); err != nil {
-		return err
-	}
-
-	return ctx.HTML(statusCode, buf.String())
-}
-
-func HomeHandler(c echo.Context) error {
-	cc := &CustomContext{c}
-	return Render(c, http.StatusOK, views.Home(views.HomeData{User: cc.User()}))
-}
-
-func LoginHandler(c echo.Context) error {
-	cc := &CustomContext{c}
-	// Check if user is already authenticated via session
-	if cc.User() != nil {
-		// User is already logged in, redirect to home page
-		return c.Redirect(http.StatusTemporaryRedirect, "/")
-	}
-
-	return c.Redirect(http.StatusTemporaryRedirect, cognitoHostedLoginURL())
-}
-
-func CognitoCallbackHandler(c echo.Context) error {
-	logger.Info("CognitoCallbackHandler: request query parameters", "query", c.Request().URL.Query())
-
-	code := c.QueryParam("code")
-	if code == "" {
-		logger.Error("CognitoCallbackHandler: no code in request")
-		return echo.NewHTTPError(http.StatusBadRequest, "No authorization code provided")
-	}
-
-	// Exchange the authorization code for tokens
-	tokenResponse, err := exchangeCodeForTokens(code)
+	cfg, err := config.Load(LambdaStage)
 	if err != nil {
-		logger.Error("CognitoCallbackHandler: failed to exchange code for tokens", "error", err)
-		return err
+		logger.Error("failed to load config", "error", err)
+		os.Exit(1)
 	}
 
-	// Get user info using the access token
-	userInfo, err := getUserInfo(tokenResponse.AccessToken)
+	useOS := len(os.Args) > 1 && os.Args[1] == "live"
+	app, err := server.New(cfg, logger, getFileSystem(useOS), server.BuildInfo{
+		Stage:     LambdaStage,
+		Commit:    GitCommit,
+		BuildTime: BuildTime,
+	})
 	if err != nil {
-		logger.Error("CognitoCallbackHandler: failed to get user info", "error", err)
-		return err
+		logger.Error("failed to set up app", "error", err)
+		os.Exit(1)
 	}
 
-	logger.Info("CognitoCallbackHandler: user info", "userInfo", userInfo)
-
-	// Store user in session
-	sess, err := session.Get(sessionName, c)
-	if err != nil {
-		logger.Error("CognitoCallbackHandler: failed to get session", "error", err)
-		return err
+	// In Lambda, handle the invocations' events directly. Anywhere else (i.e.
+	// running locally), serve on the configured address until stopped.
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		// Lambda sends SIGTERM before stopping the environment, between
+		// invocations, so there are no requests to wait for
+		lambda.StartWithOptions(lambdaadapter.New(app), lambda.WithEnableSIGTERM(func() {
+			logger.Info("Lambda: shutting down")
+			if err := app.Close(); err != nil {
+				logger.Error("Lambda: failed to close app", "error", err)
+			}
+		}))
+		return
 	}
 
-	sess.Values[sessionUserKey] = models.User{
-		ID:   userInfo.Sub,
-		Name: userInfo.Name,
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
-	if err := sess.Save(c.Request(), c.Response()); err != nil {
-		logger.Error("CognitoCallbackHandler: failed to save session", "error", err)
-		return err
+	if err := server.Serve(ctx, app, cfg.Server, logger); err != nil {
+		logger.Error("server failed", "error", err)
+		os.Exit(1)
 	}
-
-	logger.Info("CognitoCallbackHandler: completed user auth", "user", userInfo)
-	return c.Redirect(http.StatusTemporaryRedirect, "/")
+	logger.Info("server stopped")
 }
 
-func LogoutHandler(c echo.Context) error {
-	logout(c)
-	return c.Redirect(http.StatusTemporaryRedirect, cognitoHostedLogoutURL(c.Request().Host))
-}
-
-func userFromSession(c echo.Context) *models.User {
-	sess, err := session.Get(sessionName, c)
-	if err != nil {
-		logger.Error("User: failed to get session", "error", err)
-		return nil
+// redactQuery replaces secret values in the query string of the requests logged
+// by the slogecho middleware.
+func redactQuery(groups []string, a slog.Attr) slog.Attr {
+	if a.Key != "query" || !slices.Equal(groups, []string{"request"}) {
+		return a
 	}
 
-	if user, ok := sess.Values[sessionUserKey]; ok {
-		var curUser = models.User{}
-		if curUser, ok = user.(models.User); !ok {
-			logger.Error("User: user is not the proper type", "user", user)
-			return nil
-		}
-
-		return &curUser
+	query, err := url.ParseQuery(a.Value.String())
+	if err != nil {
+		return slog.String(a.Key, "[unparseable]")
 	}
 
-	return nil
-}
-
-func logout(c echo.Context) error {
-	sess, err := session.Get(sessionName, c)
-	// ignore error fetching session, as means we don't have one (most likely)
-	// also ignore error saving session, but log it in case, as it's unexpected
-	if err == nil {
-		sess.Options.MaxAge = -1 // deletes the session
-		if err := sess.Save(c.Request(), c.Response()); err != nil {
-			logger.Error("logout: failed to save session", "error", err)
+	for _, name := range redactedQueryParams {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
 		}
 	}
 
-	return nil
+	return slog.String(a.Key, query.Encode())
 }
 
 func getFileSystem(useOS bool) http.FileSystem {
@@ -213,44 +112,3 @@ func getFileSystem(useOS bool) http.FileSystem {
 
 	return http.FS(fsys)
 }
-
-func AdminHandler(c echo.Context) error {
-	cc := &CustomContext{c}
-	user := cc.User()
-
-	// For this demo app, we just see if the user's name includes "Admin".
-	// Normally you'd check a flag/role on the user record, etc.
-	if !strings.Contains(user.Name, "Admin") {
-		return echo.NewHTTPError(http.StatusForbidden, "You are not authorized to access this page")
-	}
-
-	return Render(c, http.StatusOK, views.Admin(*user))
-}
-
-func UserHandler(c echo.Context) error {
-	cc := &CustomContext{c}
-	return Render(c, http.StatusOK, views.User(*cc.User()))
-}
-
-func AddUserToContext(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		user := userFromSession(c)
-		if user != nil {
-			c.Set(contextUserKey, user)
-		}
-
-		return next(c)
-	}
-}
-
-func RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		cc := &CustomContext{c}
-		user := cc.User()
-		if user == nil {
-			return echo.NewHTTPError(http.StatusUnauthorized, "You must be logged in to access this page")
-		}
-
-		return next(c)
-	}
-}
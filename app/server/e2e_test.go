@@ -0,0 +1,293 @@
+package server_test
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"echo-cognito-auth/config"
+	"echo-cognito-auth/mockcognito"
+	"echo-cognito-auth/server"
+)
+
+// The end-to-end tests serve the real app against a fake Cognito
+// (mockcognito), and drive logins, the callback, protected routes and logout
+// through them like a browser, with the config loaded from the environment as
+// it is when deployed.
+
+const (
+	e2eSessionKeys = "e2e-hash-key-0123456789abcdef0123:e2e-encryption-key-0123456789abc"
+
+	alicePassword = "alice-password"
+	bobPassword   = "bob-password"
+)
+
+func TestEndToEnd(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(*testing.T, *e2eEnv)
+	}{
+		{"home page is public", testHomePage},
+		{"protected page redirects to login", testLoginRedirect},
+		{"login, protected page and logout", testLoginAndLogout},
+		{"wrong password stays on the login page", testWrongPassword},
+		{"admin page needs the admin group", testAdminRole},
+		{"cancelled login shows an error", testCancelledLogin},
+		{"token endpoint failure shows an error", testTokenFailure},
+		{"refresh picks up group changes", testGroupChange},
+		{"removed user is logged out", testRemovedUser},
+		{"sessions page signs out everywhere", testSignOutEverywhere},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newE2EEnv(t))
+		})
+	}
+}
+
+// e2eEnv is a fresh fake Cognito and app for a test, with a browser for it.
+type e2eEnv struct {
+	cognito *mockcognito.Server
+	appURL  string
+	browser *http.Client
+}
+
+func newE2EEnv(t *testing.T) *e2eEnv {
+	t.Helper()
+
+	e := &e2eEnv{}
+	e.cognito = mockcognito.New(mockcognito.Options{
+		// Tokens this close to expiry are refreshed on every request
+		AccessTokenTTL: time.Minute,
+	})
+	t.Cleanup(e.cognito.Close)
+	e.cognito.AddUser(mockcognito.User{Username: "alice", Password: alicePassword, Name: "Alice", Email: "alice@example.com"})
+	e.cognito.AddUser(mockcognito.User{Username: "bob", Password: bobPassword, Name: "Bob", Groups: []string{server.RoleAdmin}})
+
+	// The app has to be on localhost for plain http cookies, and its port is
+	// needed for its config
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	e.appURL = "http://localhost:" + port
+
+	for name, value := range map[string]string{
+		"APP_URL":                         e.appURL,
+		"COGNITO_USER_POOL_CLIENT_ID":     e.cognito.ClientID,
+		"COGNITO_USER_POOL_CLIENT_SECRET": e.cognito.ClientSecret,
+		"COGNITO_BASE_URL":                e.cognito.URL,
+		"COGNITO_ISSUER_URL":              e.cognito.IssuerURL(),
+		"COGNITO_REDIRECT_URI":            e.appURL + "/auth/cognito/callback",
+		"COGNITO_MAX_RETRIES":             "-1",
+		"ECHO_COGNITO_AUTH_SESSION_KEYS":  e2eSessionKeys,
+		"ECHO_COGNITO_AUTH_SESSION_STORE": config.SessionStoreMemory,
+	} {
+		t.Setenv(name, value)
+	}
+
+	cfg, err := config.Load(config.StageDev)
+	if err != nil {
+		listener.Close()
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(testLogWriter{t}, nil))
+	app, err := server.New(cfg, logger, http.Dir("../assets"), server.BuildInfo{Stage: cfg.Stage})
+	if err != nil {
+		listener.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.Close() })
+
+	srv := httptest.NewUnstartedServer(app)
+	srv.Listener.Close()
+	srv.Listener = listener
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	jar, _ := cookiejar.New(nil)
+	e.browser = &http.Client{Jar: jar, Transport: browserTransport{}}
+
+	return e
+}
+
+// testLogWriter writes the app's logs to the test's log.
+type testLogWriter struct {
+	t *testing.T
+}
+
+func (w testLogWriter) Write(p []byte) (int, error) {
+	w.t.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// browserTransport asks for HTML, like a browser, so the app redirects to login
+// rather than returning a 401.
+type browserTransport struct{}
+
+func (browserTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Accept", "text/html")
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// page is a response, read.
+type page struct {
+	status int
+	url    string
+	body   string
+}
+
+func (p page) expect(t *testing.T, status int, text string) {
+	t.Helper()
+
+	if p.status != status {
+		t.Fatalf("%s: got status %d, want %d", p.url, p.status, status)
+	}
+	if !strings.Contains(p.body, text) {
+		t.Fatalf("%s: page doesn't contain %q", p.url, text)
+	}
+}
+
+func read(t *testing.T, resp *http.Response, err error) page {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return page{status: resp.StatusCode, url: resp.Request.URL.String(), body: string(body)}
+}
+
+// get gets the app's path, following redirects.
+func (e *e2eEnv) get(t *testing.T, path string) page {
+	t.Helper()
+	resp, err := e.browser.Get(e.appURL + path)
+	return read(t, resp, err)
+}
+
+// post posts the form to the URL, following redirects.
+func (e *e2eEnv) post(t *testing.T, u string, form url.Values) page {
+	t.Helper()
+	resp, err := e.browser.PostForm(u, form)
+	return read(t, resp, err)
+}
+
+// login goes to the app's path, and logs in on the Cognito login page it's
+// redirected to.
+func (e *e2eEnv) login(t *testing.T, path, username, password string) page {
+	t.Helper()
+
+	loginPage := e.get(t, path)
+	loginPage.expect(t, http.StatusOK, "Sign in")
+	if !strings.HasPrefix(loginPage.url, e.cognito.URL+mockcognito.PathLogin) {
+		t.Fatalf("expected the Cognito login page, got %s", loginPage.url)
+	}
+
+	return e.post(t, loginPage.url, url.Values{"username": {username}, "password": {password}})
+}
+
+func testHomePage(t *testing.T, e *e2eEnv) {
+	e.get(t, "/").expect(t, http.StatusOK, "Login/Signup")
+}
+
+func testLoginRedirect(t *testing.T, e *e2eEnv) {
+	e.browser.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	resp, err := e.browser.Get(e.appURL + "/user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/login?return_to=%2Fuser" {
+		t.Errorf("got %d to %q, want a redirect to login", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func testLoginAndLogout(t *testing.T, e *e2eEnv) {
+	p := e.login(t, "/user", "alice", alicePassword)
+	p.expect(t, http.StatusOK, "Welcome, Alice!")
+	if p.url != e.appURL+"/user" {
+		t.Fatalf("login returned to %s, want /user", p.url)
+	}
+
+	e.get(t, "/logout").expect(t, http.StatusOK, "Login/Signup")
+
+	// Logged out of Cognito too, so it asks for the password again
+	e.get(t, "/user").expect(t, http.StatusOK, "Sign in")
+}
+
+func testWrongPassword(t *testing.T, e *e2eEnv) {
+	e.login(t, "/user", "alice", "not-the-password").expect(t, http.StatusOK, "Incorrect username or password.")
+}
+
+func testAdminRole(t *testing.T, e *e2eEnv) {
+	e.login(t, "/admin", "alice", alicePassword).expect(t, http.StatusForbidden, "Forbidden")
+
+	e.get(t, "/logout")
+
+	e.login(t, "/admin", "bob", bobPassword).expect(t, http.StatusOK, "only accessible to admin users")
+}
+
+func testCancelledLogin(t *testing.T, e *e2eEnv) {
+	e.cognito.FailNext(mockcognito.PathLogin, mockcognito.Failure{Code: "access_denied", Description: "User cancelled login"})
+
+	e.login(t, "/user", "alice", alicePassword).expect(t, http.StatusBadRequest, "The login was cancelled")
+}
+
+func testTokenFailure(t *testing.T, e *e2eEnv) {
+	e.cognito.FailNext(mockcognito.PathToken, mockcognito.Failure{Status: http.StatusServiceUnavailable, Description: "Service Unavailable"})
+
+	e.login(t, "/user", "alice", alicePassword).expect(t, http.StatusInternalServerError, "Something went wrong")
+}
+
+func testGroupChange(t *testing.T, e *e2eEnv) {
+	e.login(t, "/admin", "alice", alicePassword).expect(t, http.StatusForbidden, "Forbidden")
+
+	e.cognito.SetGroups("alice", server.RoleAdmin)
+
+	e.get(t, "/admin").expect(t, http.StatusOK, "only accessible to admin users")
+}
+
+func testRemovedUser(t *testing.T, e *e2eEnv) {
+	e.login(t, "/user", "alice", alicePassword).expect(t, http.StatusOK, "Welcome, Alice!")
+
+	e.cognito.RemoveUser("alice")
+
+	e.get(t, "/").expect(t, http.StatusOK, "Login/Signup")
+}
+
+func testSignOutEverywhere(t *testing.T, e *e2eEnv) {
+	p := e.login(t, "/user", "alice", alicePassword)
+	p.expect(t, http.StatusOK, "This session")
+
+	csrf := formValue(t, p.body, "_csrf")
+	e.post(t, e.appURL+"/user/sessions/revoke-all", url.Values{"_csrf": {csrf}}).expect(t, http.StatusOK, "Login/Signup")
+}
+
+// formValue returns the value of the first input with the name in the HTML.
+func formValue(t *testing.T, html, name string) string {
+	t.Helper()
+
+	_, after, ok := strings.Cut(html, `name="`+name+`" value="`)
+	if !ok {
+		t.Fatalf("no %s input on the page", name)
+	}
+	value, _, _ := strings.Cut(after, `"`)
+	return value
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo/v4"
+
+	"echo-cognito-auth/cognitoauth"
+	"echo-cognito-auth/sessionstore"
+)
+
+const (
+	// Paths of the health check routes, for load balancers and the like.
+	HealthPath  = "/healthz"
+	ReadyPath   = "/readyz"
+	VersionPath = "/version"
+
+	// jwksCheckInterval is how long the JWKS check's result is kept, so
+	// frequent readiness checks don't each call Cognito.
+	jwksCheckInterval = time.Minute
+	// readyTimeout limits how long the readiness checks take.
+	readyTimeout = 5 * time.Second
+
+	checkOK     = "ok"
+	checkFailed = "failed"
+)
+
+// BuildInfo describes the build, for the version route. It's set with go build
+// ldflags, see build.sh.
+type BuildInfo struct {
+	Stage     string `json:"stage"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+}
+
+// healthPaths are served without the session and user middleware, so checks
+// are cheap and don't make sessions.
+var healthPaths = []string{HealthPath, ReadyPath, VersionPath}
+
+// isHealthCheck is a middleware.Skipper for the health check routes.
+func isHealthCheck(c echo.Context) bool {
+	return slices.Contains(healthPaths, c.Path())
+}
+
+// skipHealthChecks runs the middleware for every route but the health checks.
+func skipHealthChecks(m echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withMiddleware := m(next)
+		return func(c echo.Context) error {
+			if isHealthCheck(c) {
+				return next(c)
+			}
+			return withMiddleware(c)
+		}
+	}
+}
+
+// health serves the health check routes.
+type health struct {
+	auth   *cognitoauth.Auth
+	store  sessions.Store
+	build  BuildInfo
+	logger *slog.Logger
+
+	mu            sync.Mutex
+	jwksErr       error
+	jwksCheckedAt time.Time
+}
+
+func setupHealthRoutes(e *echo.Echo, h *health) {
+	e.GET(HealthPath, h.healthHandler)
+	e.GET(ReadyPath, h.readyHandler)
+	e.GET(VersionPath, h.versionHandler)
+}
+
+// healthHandler reports the app is running.
+func (h *health) healthHandler(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.JSON(http.StatusOK, map[string]string{"status": checkOK})
+}
+
+// readyHandler reports whether the app can serve requests: it can reach
+// Cognito's JWKS and the session backend. It's 503 if either fails, and the
+// errors are logged rather than shown. The config isn't checked, as New only
+// gets a config that config.Load has already validated.
+func (h *health) readyHandler(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), readyTimeout)
+	defer cancel()
+
+	status, code := checkOK, http.StatusOK
+	checks := map[string]string{}
+	check := func(name string, err error) {
+		checks[name] = checkOK
+		if err != nil {
+			h.logger.Error("readyHandler: check failed", "check", name, "error", err)
+			checks[name] = checkFailed
+			status, code = checkFailed, http.StatusServiceUnavailable
+		}
+	}
+
+	check("jwks", h.checkJWKS(ctx))
+	check("sessions", h.checkSessions(ctx))
+
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.JSON(code, map[string]any{"status": status, "checks": checks})
+}
+
+// checkJWKS checks Cognito's JWKS can be fetched, reusing the last result if it
+// was recent.
+func (h *health) checkJWKS(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.jwksCheckedAt.IsZero() && time.Since(h.jwksCheckedAt) < jwksCheckInterval {
+		return h.jwksErr
+	}
+
+	h.jwksErr = h.auth.CheckJWKS(ctx)
+	h.jwksCheckedAt = time.Now()
+	return h.jwksErr
+}
+
+// checkSessions checks the session backend can be reached. Other stores have no
+// backend to check.
+func (h *health) checkSessions(ctx context.Context) error {
+	store, ok := h.store.(*sessionstore.Store)
+	if !ok {
+		return nil
+	}
+	return store.Ping(ctx)
+}
+
+// versionHandler reports the build's stage, git commit and build time.
+func (h *health) versionHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.build)
+}
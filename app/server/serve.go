@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"echo-cognito-auth/config"
+)
+
+// Serve serves the app on the configured address (with https if there's a
+// certificate) until ctx is done, and then shuts it down gracefully: it stops
+// accepting connections, and waits up to the ShutdownTimeout for requests in
+// progress to finish. Any still running after that are cancelled through their
+// contexts, which stops the Cognito calls they're making. Then the app is
+// closed. The shutdown is logged to the logger.
+func Serve(ctx context.Context, app *App, cfg config.ServerConfig, logger *slog.Logger) error {
+	e := app.Echo
+	requestCtx, cancelRequests := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelRequests()
+	baseContext := func(net.Listener) context.Context { return requestCtx }
+	e.Server.BaseContext = baseContext
+	e.TLSServer.BaseContext = baseContext
+
+	errc := make(chan error, 1)
+	go func() {
+		if cfg.TLSCertFile != "" {
+			errc <- e.StartTLS(cfg.Address, cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			errc <- e.Start(cfg.Address)
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		// It didn't start
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("Serve: shutting down", "timeout", cfg.ShutdownTimeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Serve: requests didn't finish in time, cancelling them", "error", err)
+		cancelRequests()
+		if err := e.Close(); err != nil {
+			return err
+		}
+	}
+
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return app.Close()
+}
@@ -0,0 +1,238 @@
+// Package server builds the web app: the Echo server with its middleware,
+// Cognito auth and routes. It's separate from package main so the app can be
+// driven by tests and tools as well as served.
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/a-h/templ"
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	slogecho "github.com/samber/slog-echo"
+
+	"echo-cognito-auth/cognitoauth"
+	"echo-cognito-auth/config"
+	"echo-cognito-auth/views"
+)
+
+const (
+	// RoleAdmin is the Cognito user pool group for admin users
+	RoleAdmin = "admin"
+)
+
+// App is the app's Echo instance, with the session store it holds open.
+type App struct {
+	*echo.Echo
+
+	store sessions.Store
+}
+
+// Close closes the session store (e.g. the bolt database file). Call it once
+// the app has stopped serving requests, as Serve does.
+func (a *App) Close() error {
+	if closer, ok := a.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// New builds the app for the config, logging to the logger, and serving the
+// static assets from the file system. The build info is shown by the version
+// route.
+func New(cfg *config.Config, logger *slog.Logger, assets http.FileSystem, build BuildInfo) (*App, error) {
+	app := echo.New()
+
+	// The store is chosen in the config, see newSessionStore.
+	store, err := newSessionStore(context.Background(), cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := setupMiddleware(app, cfg, store, logger)
+	if err != nil {
+		return nil, err
+	}
+	setupHealthRoutes(app, &health{auth: auth, store: store, build: build, logger: logger})
+	setupRoutes(app, cfg, auth, assets, logger)
+
+	return &App{Echo: app, store: store}, nil
+}
+
+// setupMiddleware adds the middleware, and the Cognito auth which registers the
+// login, logout and callback routes. The health checks skip the session and
+// user, and are only logged when they fail.
+func setupMiddleware(e *echo.Echo, cfg *config.Config, store sessions.Store, logger *slog.Logger) (*cognitoauth.Auth, error) {
+	e.HTTPErrorHandler = HTTPErrorHandler(logger)
+	e.Use(slogecho.NewWithFilters(logger, func(c echo.Context) bool {
+		return !isHealthCheck(c) || c.Response().Status >= http.StatusBadRequest
+	}))
+	e.Use(middleware.Recover())
+
+	e.Use(session.MiddlewareWithConfig(session.Config{Skipper: isHealthCheck, Store: store}))
+
+	auth, err := cognitoauth.New(e, cognitoauth.Config{
+		ClientID:         cfg.Cognito.ClientID,
+		ClientSecret:     cfg.Cognito.ClientSecret,
+		BaseURL:          cfg.Cognito.BaseURL,
+		RedirectURI:      cfg.Cognito.RedirectURI,
+		IssuerURL:        cfg.Cognito.IssuerURL,
+		DisableDiscovery: cfg.Cognito.DisableDiscovery,
+		APIClientIDs:     cfg.Cognito.APIClientIDs,
+		Scopes:           []string{"openid", "email", "profile", cognitoauth.ScopeUserAdmin},
+		SessionName:      cfg.Session.Name(),
+		IdleTimeout:      cfg.Session.IdleTimeout,
+		MaxSessionAge:    cfg.Session.MaxLifetime,
+		Client: cognitoauth.NewClient(cognitoauth.ClientOptions{
+			Timeout:    cfg.Cognito.HTTPTimeout,
+			MaxRetries: cfg.Cognito.MaxRetries,
+			Logger:     logger,
+		}),
+		Logger: logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// This needs the session, so needs to be after session middleware
+	e.Use(skipHealthChecks(auth.AddUserToContext))
+
+	return auth, nil
+}
+
+func setupRoutes(e *echo.Echo, cfg *config.Config, auth *cognitoauth.Auth, assets http.FileSystem, logger *slog.Logger) {
+	assetHandler := http.FileServer(assets)
+	e.GET("/assets/*", echo.WrapHandler(http.StripPrefix("/assets/", assetHandler)))
+
+	e.GET("/", HomeHandler)
+
+	// Protected routes
+	adminGroup := e.Group("/admin", auth.RequireAuth, auth.RequireRole(RoleAdmin))
+	adminGroup.GET("", AdminHandler)
+
+	userGroup := e.Group("/user", auth.RequireAuth, csrfMiddleware(cfg))
+	userGroup.GET("", UserHandler(auth, logger))
+	userGroup.POST("/sessions/revoke", auth.RevokeSessionHandler)
+	userGroup.POST("/sessions/revoke-all", auth.SignOutEverywhereHandler)
+
+	// API routes, for clients with a Cognito access token with the configured
+	// scopes
+	apiGroup := e.Group("/api", auth.RequireBearerToken(cfg.Cognito.APIScopes...))
+	apiGroup.GET("/user", APIUserHandler)
+}
+
+// csrfMiddleware protects the forms on the user pages, which post their token
+// in the _csrf field.
+func csrfMiddleware(cfg *config.Config) echo.MiddlewareFunc {
+	return middleware.CSRFWithConfig(middleware.CSRFConfig{
+		TokenLookup:    "form:_csrf",
+		CookiePath:     "/user",
+		CookieHTTPOnly: true,
+		CookieSecure:   cfg.SecureCookies(),
+		CookieSameSite: http.SameSiteLaxMode,
+	})
+}
+
+// This custom Render replaces Echo's echo.Context.Render() with templ's templ.Component.Render().
+func Render(ctx echo.Context, statusCode int, t templ.Component) error {
+	buf := templ.GetBuffer()
+	defer templ.ReleaseBuffer(buf)
+
+	if err := t.Render(ctx.Request().Context(), buf); err != nil {
+		return err
+	}
+
+	return ctx.HTML(statusCode, buf.String())
+}
+
+func HomeHandler(c echo.Context) error {
+	cc := &cognitoauth.CustomContext{Context: c}
+	return Render(c, http.StatusOK, views.Home(views.HomeData{User: cc.User()}))
+}
+
+func AdminHandler(c echo.Context) error {
+	// Only admins get here, which the RequireRole middleware on the route
+	// group takes care of.
+	cc := &cognitoauth.CustomContext{Context: c}
+	return Render(c, http.StatusOK, views.Admin(*cc.User()))
+}
+
+// UserHandler shows the user's page, with their sessions so they can sign out
+// of them.
+func UserHandler(auth *cognitoauth.Auth, logger *slog.Logger) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		cc := &cognitoauth.CustomContext{Context: c}
+
+		userSessions, err := auth.UserSessions(c)
+		if err != nil {
+			logger.Error("UserHandler: failed to list sessions", "error", err)
+			return err
+		}
+
+		csrfToken, _ := c.Get(middleware.DefaultCSRFConfig.ContextKey).(string)
+		return Render(c, http.StatusOK, views.User(views.UserData{
+			User:      *cc.User(),
+			Sessions:  userSessions,
+			CSRFToken: csrfToken,
+		}))
+	}
+}
+
+func APIUserHandler(c echo.Context) error {
+	cc := &cognitoauth.CustomContext{Context: c}
+	return c.JSON(http.StatusOK, cc.User())
+}
+
+// HTTPErrorHandler renders errors as a page for browsers, and leaves everything
+// else to Echo's default (JSON) error handler. Server errors are logged.
+func HTTPErrorHandler(logger *slog.Logger) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		handleError(err, c, logger)
+	}
+}
+
+func handleError(err error, c echo.Context, logger *slog.Logger) {
+	if c.Response().Committed || !cognitoauth.WantsHTML(c) {
+		c.Echo().DefaultHTTPErrorHandler(err, c)
+		return
+	}
+
+	code := http.StatusInternalServerError
+	message := "Something went wrong, please try again later."
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		code = he.Code
+		if m, ok := he.Message.(string); ok && code < http.StatusInternalServerError {
+			message = m
+		}
+	}
+
+	if code >= http.StatusInternalServerError {
+		logger.Error("HTTPErrorHandler: request failed", "error", err)
+	}
+
+	title := http.StatusText(code)
+	var retryURL string
+	// Login errors get a link to try again
+	var loginErr *cognitoauth.LoginError
+	if errors.As(err, &loginErr) {
+		title = "Login failed"
+		retryURL = loginErr.RetryURL
+	}
+
+	cc := &cognitoauth.CustomContext{Context: c}
+	if err := Render(c, code, views.Error(views.ErrorData{
+		Title:    title,
+		Message:  message,
+		RetryURL: retryURL,
+		User:     cc.User(),
+	})); err != nil {
+		logger.Error("HTTPErrorHandler: failed to render error page", "error", err)
+	}
+}
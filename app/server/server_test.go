@@ -0,0 +1,152 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"echo-cognito-auth/apptest"
+	"echo-cognito-auth/cognitoauth"
+	"echo-cognito-auth/config"
+	"echo-cognito-auth/mockcognito"
+	"echo-cognito-auth/models"
+	"echo-cognito-auth/server"
+	"echo-cognito-auth/views"
+)
+
+func TestAPIScopes(t *testing.T) {
+	t.Parallel()
+
+	app := apptest.New(t, apptest.Options{Configure: func(cfg *config.Config) {
+		cfg.Cognito.APIScopes = []string{"https://api.example.com/read"}
+	}})
+	alice := models.User{ID: "alice-id", Name: "alice"}
+
+	tests := []struct {
+		name   string
+		scopes []string
+		want   int
+	}{
+		{"required scope", []string{"openid", "https://api.example.com/read"}, http.StatusOK},
+		{"missing scope", []string{"openid"}, http.StatusForbidden},
+		{"no scopes", nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := app.GetWithToken("/api/user", app.BearerToken(t, alice, tt.scopes...))
+			apptest.AssertStatus(t, rec, tt.want)
+		})
+	}
+
+	rec := app.GetWithToken("/api/user", "not-a-token")
+	apptest.AssertStatus(t, rec, http.StatusUnauthorized)
+}
+
+func TestAdminHandler(t *testing.T) {
+	t.Parallel()
+
+	app := apptest.New(t, apptest.Options{})
+	bob := models.User{ID: "bob-id", Name: "Bob", Groups: []string{server.RoleAdmin}}
+	alice := models.User{ID: "alice-id", Name: "Alice"}
+
+	rec := app.Get("/admin", app.SessionCookie(t, bob))
+	apptest.AssertStatus(t, rec, http.StatusOK)
+	apptest.AssertRendered(t, rec, views.Admin(bob))
+
+	apptest.AssertStatus(t, app.Get("/admin", app.SessionCookie(t, alice)), http.StatusForbidden)
+	apptest.AssertRedirect(t, app.Get("/admin", nil), cognitoauth.DefaultLoginPath)
+}
+
+func TestUserHandler(t *testing.T) {
+	t.Parallel()
+
+	app := apptest.New(t, apptest.Options{})
+	alice := models.User{ID: "alice-id", Name: "Alice"}
+
+	apptest.AssertRedirect(t, app.Get("/user", nil), cognitoauth.DefaultLoginPath)
+
+	// Alice is logged in in two browsers
+	laptop := app.SessionCookie(t, alice)
+	phone := app.SessionCookie(t, alice)
+
+	rec := app.Get("/user", laptop)
+	apptest.AssertStatus(t, rec, http.StatusOK)
+	apptest.AssertContains(t, rec, "Welcome, Alice!")
+	rows := sessionRows(rec.Body.String())
+	if len(rows) != 2 {
+		t.Fatalf("the page lists %d sessions, want 2", len(rows))
+	}
+
+	// Sign the phone out from the laptop
+	var phoneHandle string
+	for _, row := range rows {
+		if !strings.Contains(row, "This session") {
+			phoneHandle = inputValue(row, cognitoauth.SessionHandleParam)
+		}
+	}
+	if phoneHandle == "" {
+		t.Fatal("the page has no other session to sign out")
+	}
+	rec = app.PostForm("/user/sessions/revoke", url.Values{cognitoauth.SessionHandleParam: {phoneHandle}}, laptop)
+	apptest.AssertRedirect(t, rec, "/user")
+
+	apptest.AssertRedirect(t, app.Get("/user", phone), cognitoauth.DefaultLoginPath)
+	rec = app.Get("/user", laptop)
+	apptest.AssertStatus(t, rec, http.StatusOK)
+	if rows := sessionRows(rec.Body.String()); len(rows) != 1 || !strings.Contains(rows[0], "This session") {
+		t.Errorf("the page lists %d sessions, want only this one", len(rows))
+	}
+
+	// The forms need the CSRF token
+	req := httptest.NewRequest(http.MethodPost, app.Config.AppURL+"/user/sessions/revoke-all", nil)
+	req.AddCookie(laptop)
+	apptest.AssertStatus(t, app.Serve(req), http.StatusBadRequest)
+
+	// Signing out everywhere signs out of Cognito too
+	rec = app.PostForm("/user/sessions/revoke-all", nil, laptop)
+	apptest.AssertRedirect(t, rec, app.Cognito.URL+mockcognito.PathLogout)
+	apptest.AssertRedirect(t, app.Get("/user", laptop), cognitoauth.DefaultLoginPath)
+}
+
+// sessionRows returns the rows of the user page's sessions table, without the
+// header.
+func sessionRows(html string) []string {
+	_, table, _ := strings.Cut(html, `<table id="sessions">`)
+	table, _, _ = strings.Cut(table, "</table>")
+	rows := strings.Split(table, "<tr>")
+	if len(rows) < 2 {
+		return nil
+	}
+	return rows[2:]
+}
+
+// inputValue returns the value of the first input with the name in the HTML.
+func inputValue(html, name string) string {
+	_, after, ok := strings.Cut(html, `name="`+name+`" value="`)
+	if !ok {
+		return ""
+	}
+	value, _, _ := strings.Cut(after, `"`)
+	return value
+}
+
+func TestReadyHandler(t *testing.T) {
+	t.Parallel()
+
+	app := apptest.New(t, apptest.Options{})
+
+	rec := app.Get(server.ReadyPath, nil)
+	apptest.AssertStatus(t, rec, http.StatusOK)
+	apptest.AssertContains(t, rec, `"checks":{"jwks":"ok","sessions":"ok"}`)
+
+	// The JWKS check's result is reused for a minute, so a new app is needed
+	// to see Cognito fail
+	app = apptest.New(t, apptest.Options{})
+	app.Cognito.Close()
+
+	rec = app.Get(server.ReadyPath, nil)
+	apptest.AssertStatus(t, rec, http.StatusServiceUnavailable)
+	apptest.AssertContains(t, rec, `"jwks":"failed"`)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/gorilla/sessions"
+
+	"echo-cognito-auth/config"
+	"echo-cognito-auth/sessionstore"
+)
+
+// newSessionStore creates the session store chosen in the config.
+func newSessionStore(ctx context.Context, appCfg *config.Config, logger *slog.Logger) (sessions.Store, error) {
+	cfg := appCfg.Session
+	keyPairs := cfg.KeyPairs()
+	maxAge := int(cfg.TTL.Seconds())
+	options := sessionCookieOptions(appCfg)
+
+	var backend sessionstore.Backend
+	switch cfg.Store {
+	case config.SessionStoreMemory:
+		backend = sessionstore.NewMemory()
+	case config.SessionStoreBolt:
+		bolt, err := sessionstore.OpenBolt(cfg.BoltPath)
+		if err != nil {
+			return nil, err
+		}
+		backend = bolt
+	case config.SessionStoreDynamoDB:
+		awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS config: %w", err)
+		}
+
+		client := dynamodb.NewFromConfig(awsCfg, func(o *dynamodb.Options) {
+			if cfg.DynamoDBEndpoint != "" {
+				o.BaseEndpoint = aws.String(cfg.DynamoDBEndpoint)
+			}
+		})
+		backend = sessionstore.NewDynamoDB(client, cfg.DynamoDBTable)
+	default:
+		return nil, fmt.Errorf("unknown session store %q", cfg.Store)
+	}
+
+	store := sessionstore.New(backend, keyPairs...)
+	store.Options = options
+	store.Logger = logger
+	store.MaxAge(maxAge)
+	logger.Info("newSessionStore: using server side sessions", "store", cfg.Store)
+
+	return store, nil
+}
+
+// sessionCookieOptions returns the session cookie's options. The cookie is
+// never readable from JavaScript, is only sent over https (except when running
+// on localhost), and isn't sent with cross-site subrequests or POSTs. Lax still
+// sends it when Cognito redirects back to the login callback.
+func sessionCookieOptions(cfg *config.Config) *sessions.Options {
+	return &sessions.Options{
+		Path:     "/",
+		Domain:   cfg.Session.CookieDomain,
+		HttpOnly: true,
+		Secure:   cfg.SecureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
@@ -0,0 +1,215 @@
+package sessionstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltBucket      = []byte("sessions")
+	boltUsersBucket = []byte("users") // keys are userID + 0 + session ID
+)
+
+// Bolt keeps sessions in a bbolt database file, so they survive restarts of a
+// single server. The file can only be opened by one process at a time.
+type Bolt struct {
+	db *bolt.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// OpenBolt opens (or creates) the database file at path.
+func OpenBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session database %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltUsersBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create session buckets: %w", err)
+	}
+
+	return &Bolt{db: db, lastSweep: time.Now()}, nil
+}
+
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+// boltValue is a stored session. It's encoded as the expiry time (Unix
+// seconds), the length of the JSON encoded info, the info, then the data.
+type boltValue struct {
+	info      Info
+	data      []byte
+	expiresAt time.Time
+}
+
+func (v boltValue) encode() ([]byte, error) {
+	info, err := json.Marshal(v.info)
+	if err != nil {
+		return nil, err
+	}
+
+	value := make([]byte, 12, 12+len(info)+len(v.data))
+	binary.BigEndian.PutUint64(value, uint64(v.expiresAt.Unix()))
+	binary.BigEndian.PutUint32(value[8:], uint32(len(info)))
+	value = append(value, info...)
+	return append(value, v.data...), nil
+}
+
+// decodeBoltValue decodes the value, which is only valid for the transaction
+// it was read in.
+func decodeBoltValue(value []byte) (boltValue, bool) {
+	if len(value) < 12 {
+		return boltValue{}, false
+	}
+
+	v := boltValue{expiresAt: time.Unix(int64(binary.BigEndian.Uint64(value)), 0)}
+	infoLen := int(binary.BigEndian.Uint32(value[8:]))
+	if len(value) < 12+infoLen || json.Unmarshal(value[12:12+infoLen], &v.info) != nil {
+		return boltValue{}, false
+	}
+	v.data = value[12+infoLen:]
+
+	return v, true
+}
+
+func (v boltValue) expired(now time.Time) bool {
+	return !now.Before(v.expiresAt)
+}
+
+func boltUserKey(userID, id string) []byte {
+	return []byte(userID + "\x00" + id)
+}
+
+func (b *Bolt) Load(_ context.Context, id string) ([]byte, error) {
+	var data []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v, ok := decodeBoltValue(tx.Bucket(boltBucket).Get([]byte(id)))
+		if !ok || v.expired(time.Now()) {
+			return ErrNotFound
+		}
+
+		data = bytes.Clone(v.data)
+		return nil
+	})
+
+	return data, err
+}
+
+func (b *Bolt) Save(_ context.Context, id string, info Info, data []byte, expiresAt time.Time) error {
+	value, err := boltValue{info: info, data: data, expiresAt: expiresAt}.encode()
+	if err != nil {
+		return err
+	}
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		sessions, users := tx.Bucket(boltBucket), tx.Bucket(boltUsersBucket)
+
+		// Move the session in the index if its user changed
+		if old, ok := decodeBoltValue(sessions.Get([]byte(id))); ok && old.info.UserID != "" && old.info.UserID != info.UserID {
+			if err := users.Delete(boltUserKey(old.info.UserID, id)); err != nil {
+				return err
+			}
+		}
+		if info.UserID != "" {
+			if err := users.Put(boltUserKey(info.UserID, id), nil); err != nil {
+				return err
+			}
+		}
+
+		return sessions.Put([]byte(id), value)
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.sweep()
+}
+
+func (b *Bolt) Delete(_ context.Context, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return deleteBoltSession(tx, []byte(id))
+	})
+}
+
+func deleteBoltSession(tx *bolt.Tx, id []byte) error {
+	sessions := tx.Bucket(boltBucket)
+	if old, ok := decodeBoltValue(sessions.Get(id)); ok && old.info.UserID != "" {
+		if err := tx.Bucket(boltUsersBucket).Delete(boltUserKey(old.info.UserID, string(id))); err != nil {
+			return err
+		}
+	}
+
+	return sessions.Delete(id)
+}
+
+func (b *Bolt) ListByUser(_ context.Context, userID string) ([]StoredSession, error) {
+	var stored []StoredSession
+	err := b.db.View(func(tx *bolt.Tx) error {
+		sessions := tx.Bucket(boltBucket)
+		prefix := boltUserKey(userID, "")
+		now := time.Now()
+
+		c := tx.Bucket(boltUsersBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			id := k[len(prefix):]
+			v, ok := decodeBoltValue(sessions.Get(id))
+			if !ok || v.expired(now) {
+				continue
+			}
+			stored = append(stored, StoredSession{ID: string(id), Info: v.info, ExpiresAt: v.expiresAt})
+		}
+		return nil
+	})
+
+	return stored, err
+}
+
+// sweep removes expired sessions, if it hasn't been done recently.
+func (b *Bolt) sweep() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(b.lastSweep) < sweepInterval {
+		return nil
+	}
+	b.lastSweep = now
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		var expired [][]byte
+		err := tx.Bucket(boltBucket).ForEach(func(k, value []byte) error {
+			if v, ok := decodeBoltValue(value); !ok || v.expired(now) {
+				expired = append(expired, bytes.Clone(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range expired {
+			if err := deleteBoltSession(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,166 @@
+package sessionstore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoDBAPI is the part of the DynamoDB client the backend uses.
+type DynamoDBAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+}
+
+// DynamoDBUserIndex is the name of the table's global secondary index on the
+// "userID" attribute, which must project all attributes apart from "data".
+const DynamoDBUserIndex = "userID-index"
+
+// DynamoDB keeps sessions in a DynamoDB table, so they're shared by every
+// Lambda instance. The table's partition key is the string attribute "id", and
+// it needs the DynamoDBUserIndex. Enable TTL on the "expiresAt" attribute so
+// DynamoDB removes expired sessions (which can take a while, so they're also
+// checked on load).
+type DynamoDB struct {
+	client DynamoDBAPI
+	table  string
+}
+
+func NewDynamoDB(client DynamoDBAPI, table string) *DynamoDB {
+	return &DynamoDB{client: client, table: table}
+}
+
+func (d *DynamoDB) Load(ctx context.Context, id string) ([]byte, error) {
+	out, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("DynamoDB GetItem failed: %w", err)
+	}
+
+	data, ok := out.Item["data"].(*types.AttributeValueMemberB)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if !time.Now().Before(unixAttribute(out.Item, "expiresAt")) {
+		return nil, ErrNotFound
+	}
+
+	return data.Value, nil
+}
+
+func unixAttribute(item map[string]types.AttributeValue, name string) time.Time {
+	if n, ok := item[name].(*types.AttributeValueMemberN); ok {
+		if v, err := strconv.ParseInt(n.Value, 10, 64); err == nil {
+			return time.Unix(v, 0)
+		}
+	}
+	return time.Time{}
+}
+
+func stringAttribute(item map[string]types.AttributeValue, name string) string {
+	if s, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
+
+func unixValue(t time.Time) *types.AttributeValueMemberN {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.Unix(), 10)}
+}
+
+func (d *DynamoDB) Save(ctx context.Context, id string, info Info, data []byte, expiresAt time.Time) error {
+	item := map[string]types.AttributeValue{
+		"id":         &types.AttributeValueMemberS{Value: id},
+		"data":       &types.AttributeValueMemberB{Value: data},
+		"expiresAt":  unixValue(expiresAt),
+		"createdAt":  unixValue(info.CreatedAt),
+		"lastSeenAt": unixValue(info.LastSeenAt),
+		"ip":         &types.AttributeValueMemberS{Value: info.IP},
+		"userAgent":  &types.AttributeValueMemberS{Value: info.UserAgent},
+	}
+	// Index keys can't be empty, so sessions without a user aren't indexed
+	if info.UserID != "" {
+		item["userID"] = &types.AttributeValueMemberS{Value: info.UserID}
+	}
+	if info.ProviderSessionID != "" {
+		item["providerSessionID"] = &types.AttributeValueMemberS{Value: info.ProviderSessionID}
+	}
+
+	_, err := d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("DynamoDB PutItem failed: %w", err)
+	}
+
+	return nil
+}
+
+func (d *DynamoDB) Delete(ctx context.Context, id string) error {
+	_, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.table),
+		Key:       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}},
+	})
+	if err != nil {
+		return fmt.Errorf("DynamoDB DeleteItem failed: %w", err)
+	}
+
+	return nil
+}
+
+func (d *DynamoDB) ListByUser(ctx context.Context, userID string) ([]StoredSession, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(d.table),
+		IndexName:              aws.String(DynamoDBUserIndex),
+		KeyConditionExpression: aws.String("userID = :userID"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userID": &types.AttributeValueMemberS{Value: userID},
+		},
+	}
+
+	now := time.Now()
+	var stored []StoredSession
+	for {
+		out, err := d.client.Query(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("DynamoDB Query failed: %w", err)
+		}
+
+		for _, item := range out.Items {
+			expiresAt := unixAttribute(item, "expiresAt")
+			if !now.Before(expiresAt) {
+				continue
+			}
+
+			stored = append(stored, StoredSession{
+				ID: stringAttribute(item, "id"),
+				Info: Info{
+					UserID:            userID,
+					CreatedAt:         unixAttribute(item, "createdAt"),
+					LastSeenAt:        unixAttribute(item, "lastSeenAt"),
+					IP:                stringAttribute(item, "ip"),
+					UserAgent:         stringAttribute(item, "userAgent"),
+					ProviderSessionID: stringAttribute(item, "providerSessionID"),
+				},
+				ExpiresAt: expiresAt,
+			})
+		}
+
+		if len(out.LastEvaluatedKey) == 0 {
+			return stored, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
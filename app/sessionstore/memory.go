@@ -0,0 +1,94 @@
+package sessionstore
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often backends that don't expire sessions themselves
+// remove the expired ones.
+const sweepInterval = 10 * time.Minute
+
+type memoryEntry struct {
+	info      Info
+	data      []byte
+	expiresAt time.Time
+}
+
+// Memory keeps sessions in memory, so they're lost when the process exits and
+// aren't shared between processes. It's only meant for local development and
+// tests.
+type Memory struct {
+	mu        sync.Mutex
+	sessions  map[string]memoryEntry
+	lastSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		sessions:  map[string]memoryEntry{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *Memory) Load(_ context.Context, id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.sessions[id]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		return nil, ErrNotFound
+	}
+
+	return entry.data, nil
+}
+
+func (m *Memory) Save(_ context.Context, id string, info Info, data []byte, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[id] = memoryEntry{info: info, data: data, expiresAt: expiresAt}
+	m.sweep()
+
+	return nil
+}
+
+func (m *Memory) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *Memory) ListByUser(_ context.Context, userID string) ([]StoredSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var stored []StoredSession
+	for id, entry := range m.sessions {
+		if entry.info.UserID == userID && now.Before(entry.expiresAt) {
+			stored = append(stored, StoredSession{ID: id, Info: entry.info, ExpiresAt: entry.expiresAt})
+		}
+	}
+
+	return stored, nil
+}
+
+// sweep removes expired sessions, if it hasn't been done recently. The caller
+// must hold the lock.
+func (m *Memory) sweep() {
+	now := time.Now()
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+
+	for id, entry := range m.sessions {
+		if !now.Before(entry.expiresAt) {
+			delete(m.sessions, id)
+		}
+	}
+	m.lastSweep = now
+}
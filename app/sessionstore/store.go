@@ -0,0 +1,324 @@
+// Package sessionstore is a gorilla/sessions store that keeps session data on
+// the server, in a pluggable Backend (in memory, a bbolt file, or DynamoDB).
+// The cookie only holds the session's random ID, signed and encrypted with the
+// store's keys, so nothing about the user or their tokens is sent to the
+// browser, and a session can be ended on the server.
+package sessionstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+const (
+	// DefaultMaxAge is the default session lifetime, in seconds.
+	DefaultMaxAge = 86400
+
+	// InfoKey is the session value holding the session's Info.
+	InfoKey = "session_info"
+)
+
+// ErrNotFound is returned by a Backend when there is no session with the ID,
+// or it has expired.
+var ErrNotFound = errors.New("session not found")
+
+// Info describes a session. It's kept in the session's values under InfoKey,
+// and stored alongside the session data so a user's sessions can be listed
+// without loading them.
+type Info struct {
+	UserID     string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	IP         string
+	UserAgent  string
+	// ProviderSessionID is the identity provider's ID for the login session
+	// (the ID token's sid claim), if it has one, for logging out of it.
+	ProviderSessionID string
+}
+
+func init() {
+	gob.Register(Info{})
+}
+
+// StoredSession is a session's ID and Info, as listed by a Backend.
+type StoredSession struct {
+	ID        string
+	Info      Info
+	ExpiresAt time.Time
+}
+
+// Backend stores the encoded session data by session ID, and indexes sessions
+// by their Info.UserID. Implementations must not return sessions after their
+// expiry time, even if they haven't been removed from storage yet.
+type Backend interface {
+	Load(ctx context.Context, id string) ([]byte, error)
+	Save(ctx context.Context, id string, info Info, data []byte, expiresAt time.Time) error
+	Delete(ctx context.Context, id string) error
+	ListByUser(ctx context.Context, userID string) ([]StoredSession, error)
+}
+
+// UserSession is one of a user's sessions. It has a handle instead of the
+// session ID, as the ID is as good as a password, so mustn't be shown on pages.
+type UserSession struct {
+	Handle    string
+	Info      Info
+	ExpiresAt time.Time
+}
+
+// Store is a sessions.Store keeping sessions in a Backend.
+type Store struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options // default configuration
+	Logger  *slog.Logger      // defaults to slog.Default()
+
+	backend Backend
+}
+
+// New returns a Store for the backend. The keyPairs are the same as for
+// sessions.NewCookieStore, but are only used for the ID in the cookie.
+func New(backend Backend, keyPairs ...[]byte) *Store {
+	s := &Store{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: DefaultMaxAge,
+		},
+		Logger:  slog.Default(),
+		backend: backend,
+	}
+
+	s.MaxAge(s.Options.MaxAge)
+	return s
+}
+
+// MaxAge sets the session lifetime (and the cookie's max age), in seconds.
+// Sessions expire this long after they were last saved.
+func (s *Store) MaxAge(age int) {
+	s.Options.MaxAge = age
+
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// Get returns the session from the request's registry, loading it the first
+// time. See sessions.CookieStore.Get.
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session for the ID in the request's cookie, or a new session
+// if there isn't one (or it has expired). A cookie that can't be decoded, e.g.
+// because its key has been rotated out or it was tampered with, is logged and
+// ignored, so the user gets a new session rather than an error.
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	if err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...); err != nil {
+		s.Logger.Warn("Store: ignoring session cookie that can't be decoded", "error", err)
+		session.ID = ""
+		return session, nil
+	}
+
+	data, err := s.backend.Load(r.Context(), session.ID)
+	if errors.Is(err, ErrNotFound) {
+		// Expired or deleted, so start again with a new ID
+		session.ID = ""
+		return session, nil
+	}
+	if err != nil {
+		return session, fmt.Errorf("failed to load session: %w", err)
+	}
+
+	if err := (securecookie.GobEncoder{}).Deserialize(data, &session.Values); err != nil {
+		return session, fmt.Errorf("failed to decode session: %w", err)
+	}
+
+	session.IsNew = false
+	return session, nil
+}
+
+// Save stores the session in the backend and sets the cookie with its ID. A
+// MaxAge <= 0 deletes the session and its cookie.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if err := s.backend.Delete(r.Context(), session.ID); err != nil {
+				return fmt.Errorf("failed to delete session: %w", err)
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = newID()
+	}
+
+	data, err := securecookie.GobEncoder{}.Serialize(session.Values)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+
+	info, _ := session.Values[InfoKey].(Info)
+	expiresAt := time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second)
+	if err := s.backend.Save(r.Context(), session.ID, info, data, expiresAt); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Regenerate gives the session a new ID, to be assigned when it's next saved,
+// and deletes it under the old ID. Only the values with the keep keys are kept.
+// Do this whenever the user's privileges change (e.g. logging in), so an ID an
+// attacker planted or saw beforehand is no use to them afterwards.
+func (s *Store) Regenerate(r *http.Request, session *sessions.Session, keep ...any) error {
+	if session.ID != "" {
+		if err := s.backend.Delete(r.Context(), session.ID); err != nil {
+			return fmt.Errorf("failed to delete session: %w", err)
+		}
+		session.ID = ""
+	}
+
+	kept := make(map[any]any, len(keep))
+	for _, key := range keep {
+		if value, ok := session.Values[key]; ok {
+			kept[key] = value
+		}
+	}
+	session.Values = kept
+	session.IsNew = true
+
+	return nil
+}
+
+// Handle returns the session's handle, which identifies it in UserSessions, or
+// "" if it hasn't been saved yet.
+func Handle(session *sessions.Session) string {
+	if session.ID == "" {
+		return ""
+	}
+	return handle(session.ID)
+}
+
+func handle(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum[:10])
+}
+
+// Ping checks the backend can be reached, by loading a session that doesn't
+// exist.
+func (s *Store) Ping(ctx context.Context) error {
+	if _, err := s.backend.Load(ctx, newID()); err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("session backend unavailable: %w", err)
+	}
+	return nil
+}
+
+// Close closes the backend, if it needs closing (i.e. Bolt). The store can't be
+// used afterwards.
+func (s *Store) Close() error {
+	if closer, ok := s.backend.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// UserSessions returns the user's sessions, most recently used first.
+func (s *Store) UserSessions(ctx context.Context, userID string) ([]UserSession, error) {
+	stored, err := s.backend.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	userSessions := make([]UserSession, 0, len(stored))
+	for _, ss := range stored {
+		userSessions = append(userSessions, UserSession{Handle: handle(ss.ID), Info: ss.Info, ExpiresAt: ss.ExpiresAt})
+	}
+
+	slices.SortFunc(userSessions, func(a, b UserSession) int {
+		return b.Info.LastSeenAt.Compare(a.Info.LastSeenAt)
+	})
+	return userSessions, nil
+}
+
+// DeleteUserSession deletes the user's session with the handle. It returns
+// ErrNotFound if the user has no such session, so users can only delete their
+// own sessions.
+func (s *Store) DeleteUserSession(ctx context.Context, userID, sessionHandle string) error {
+	stored, err := s.backend.ListByUser(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	for _, ss := range stored {
+		if handle(ss.ID) == sessionHandle {
+			return s.backend.Delete(ctx, ss.ID)
+		}
+	}
+
+	return ErrNotFound
+}
+
+// DeleteUserSessions deletes all of the user's sessions, returning how many
+// were deleted.
+func (s *Store) DeleteUserSessions(ctx context.Context, userID string) (int, error) {
+	return s.DeleteUserSessionsFunc(ctx, userID, nil)
+}
+
+// DeleteUserSessionsFunc deletes the user's sessions whose Info matches (all of
+// them if match is nil), returning how many were deleted.
+func (s *Store) DeleteUserSessionsFunc(ctx context.Context, userID string, match func(Info) bool) (int, error) {
+	stored, err := s.backend.ListByUser(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	deleted := 0
+	for _, ss := range stored {
+		if match != nil && !match(ss.Info) {
+			continue
+		}
+		if err := s.backend.Delete(ctx, ss.ID); err != nil {
+			return deleted, fmt.Errorf("failed to delete session: %w", err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// newID returns a random session ID, using only characters that are safe in
+// any backend's keys.
+func newID() string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(securecookie.GenerateRandomKey(32))
+}
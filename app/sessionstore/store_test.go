@@ -0,0 +1,376 @@
+package sessionstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+const testSessionName = "session"
+
+// TestBackends runs the same tests against every backend. The DynamoDB tests
+// need DynamoDB Local (see the Readme), and are skipped unless
+// DYNAMODB_ENDPOINT is set.
+func TestBackends(t *testing.T) {
+	backends := []struct {
+		name string
+		open func(t *testing.T) Backend
+	}{
+		{"memory", func(*testing.T) Backend { return NewMemory() }},
+		{"bolt", openTestBolt},
+		{"dynamodb", openTestDynamoDB},
+	}
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			testBackend(t, b.open(t))
+		})
+	}
+}
+
+// TestStoreClose checks closing the store closes a bolt backend, releasing the
+// database file, and is a no-op for the memory backend.
+func TestStoreClose(t *testing.T) {
+	if err := newTestStore(NewMemory()).Close(); err != nil {
+		t.Errorf("Close() with memory = %v, want nil", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	b, err := OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newTestStore(b)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() with bolt = %v, want nil", err)
+	}
+	if err := store.Ping(context.Background()); err == nil {
+		t.Error("Ping() after Close() = nil, want an error")
+	}
+
+	// Bolt locks the file while it's open
+	reopened, err := OpenBolt(path)
+	if err != nil {
+		t.Fatalf("OpenBolt() after Close() = %v", err)
+	}
+	reopened.Close()
+}
+
+func openTestBolt(t *testing.T) Backend {
+	b, err := OpenBolt(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// openTestDynamoDB creates a table like the one in sessions.yml, which is
+// deleted after the test.
+func openTestDynamoDB(t *testing.T) Backend {
+	endpoint := os.Getenv("DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("DYNAMODB_ENDPOINT isn't set")
+	}
+
+	ctx := context.Background()
+	client := dynamodb.New(dynamodb.Options{
+		BaseEndpoint: aws.String(endpoint),
+		Region:       "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "local", SecretAccessKey: "local"}, nil
+		}),
+	})
+
+	table := "sessionstore-test-" + newID()
+	_, err := client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:   aws.String(table),
+		BillingMode: types.BillingModePayPerRequest,
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("id"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("userID"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{{AttributeName: aws.String("id"), KeyType: types.KeyTypeHash}},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+			IndexName: aws.String(DynamoDBUserIndex),
+			KeySchema: []types.KeySchemaElement{{AttributeName: aws.String("userID"), KeyType: types.KeyTypeHash}},
+			Projection: &types.Projection{
+				ProjectionType:   types.ProjectionTypeInclude,
+				NonKeyAttributes: []string{"expiresAt", "createdAt", "lastSeenAt", "ip", "userAgent", "providerSessionID"},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+	t.Cleanup(func() {
+		client.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{TableName: aws.String(table)})
+	})
+
+	waiter := dynamodb.NewTableExistsWaiter(client)
+	if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)}, time.Minute); err != nil {
+		t.Fatalf("table wasn't created: %v", err)
+	}
+
+	return NewDynamoDB(client, table)
+}
+
+// testBackend tests the backend, and the Store with it. Each test uses new
+// session and user IDs, so they can share the backend.
+func testBackend(t *testing.T, backend Backend) {
+	ctx := context.Background()
+	// The backends store times to the second
+	now := time.Now().Truncate(time.Second)
+	expiresAt := now.Add(time.Hour)
+
+	t.Run("save and load", func(t *testing.T) {
+		id := newID()
+		if _, err := backend.Load(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Load() of a new ID error = %v, want ErrNotFound", err)
+		}
+
+		if err := backend.Save(ctx, id, Info{UserID: newID()}, []byte("data"), expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		data, err := backend.Load(ctx, id)
+		if err != nil || string(data) != "data" {
+			t.Fatalf("Load() = %q, %v, want data", data, err)
+		}
+
+		if err := backend.Save(ctx, id, Info{UserID: newID()}, []byte("updated"), expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := backend.Load(ctx, id); string(data) != "updated" {
+			t.Errorf("Load() after saving again = %q, want updated", data)
+		}
+
+		if err := backend.Delete(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := backend.Load(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Load() after Delete() error = %v, want ErrNotFound", err)
+		}
+		if err := backend.Delete(ctx, id); err != nil {
+			t.Errorf("Delete() of a deleted session error = %v, want nil", err)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		id, userID := newID(), newID()
+		if err := backend.Save(ctx, id, Info{UserID: userID}, []byte("data"), now.Add(-time.Second)); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := backend.Load(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Load() of an expired session error = %v, want ErrNotFound", err)
+		}
+		stored, err := backend.ListByUser(ctx, userID)
+		if err != nil || len(stored) != 0 {
+			t.Errorf("ListByUser() = %v, %v, want no sessions", stored, err)
+		}
+	})
+
+	t.Run("list by user", func(t *testing.T) {
+		alice, bob := newID(), newID()
+		info := Info{
+			UserID:            alice,
+			CreatedAt:         now.Add(-time.Hour),
+			LastSeenAt:        now,
+			IP:                "192.0.2.1",
+			UserAgent:         "test",
+			ProviderSessionID: "sid",
+		}
+		aliceIDs := []string{newID(), newID()}
+		for _, id := range aliceIDs {
+			if err := backend.Save(ctx, id, info, []byte("data"), expiresAt); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := backend.Save(ctx, newID(), Info{UserID: alice}, []byte("data"), now.Add(-time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if err := backend.Save(ctx, newID(), Info{UserID: bob}, []byte("data"), expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		if err := backend.Save(ctx, newID(), Info{}, []byte("data"), expiresAt); err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := backend.ListByUser(ctx, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, ss := range stored {
+			ids = append(ids, ss.ID)
+			if !sameInfo(ss.Info, info) || !ss.ExpiresAt.Equal(expiresAt) {
+				t.Errorf("ListByUser() session = %+v, want info %+v expiring at %v", ss, info, expiresAt)
+			}
+		}
+		slices.Sort(ids)
+		slices.Sort(aliceIDs)
+		if !slices.Equal(ids, aliceIDs) {
+			t.Errorf("ListByUser() IDs = %q, want %q", ids, aliceIDs)
+		}
+
+		// A deleted session isn't listed
+		if err := backend.Delete(ctx, aliceIDs[0]); err != nil {
+			t.Fatal(err)
+		}
+		if stored, _ := backend.ListByUser(ctx, alice); len(stored) != 1 || stored[0].ID != aliceIDs[1] {
+			t.Errorf("ListByUser() after Delete() = %+v, want only %s", stored, aliceIDs[1])
+		}
+	})
+
+	t.Run("store", func(t *testing.T) {
+		store := newTestStore(backend)
+		userID := newID()
+
+		rec := httptest.NewRecorder()
+		sess := getSession(t, store, nil)
+		if !sess.IsNew {
+			t.Error("session without a cookie isn't new")
+		}
+		sess.Values["name"] = "alice"
+		sess.Values[InfoKey] = Info{UserID: userID, LastSeenAt: now}
+		if err := store.Save(httptest.NewRequest(http.MethodGet, "/", nil), rec, sess); err != nil {
+			t.Fatal(err)
+		}
+		cookie := sessionCookie(t, rec)
+
+		loaded := getSession(t, store, cookie)
+		if loaded.IsNew || loaded.ID != sess.ID || loaded.Values["name"] != "alice" {
+			t.Errorf("loaded session = %+v, want the saved one", loaded)
+		}
+
+		userSessions, err := store.UserSessions(ctx, userID)
+		if err != nil || len(userSessions) != 1 || userSessions[0].Handle != Handle(sess) {
+			t.Errorf("UserSessions() = %+v, %v, want the session", userSessions, err)
+		}
+	})
+
+	t.Run("regenerate", func(t *testing.T) {
+		store := newTestStore(backend)
+
+		sess := getSession(t, store, nil)
+		sess.Values["keep"] = "kept"
+		sess.Values["drop"] = "dropped"
+		rec := httptest.NewRecorder()
+		if err := store.Save(httptest.NewRequest(http.MethodGet, "/", nil), rec, sess); err != nil {
+			t.Fatal(err)
+		}
+		oldCookie := sessionCookie(t, rec)
+		oldID := sess.ID
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if err := store.Regenerate(req, sess, "keep"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := backend.Load(ctx, oldID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Load() of the old ID error = %v, want ErrNotFound", err)
+		}
+		if _, ok := sess.Values["drop"]; ok || sess.Values["keep"] != "kept" {
+			t.Errorf("values after Regenerate() = %v, want only keep", sess.Values)
+		}
+
+		rec = httptest.NewRecorder()
+		if err := store.Save(req, rec, sess); err != nil {
+			t.Fatal(err)
+		}
+		if sess.ID == oldID {
+			t.Error("Regenerate() kept the session ID")
+		}
+		if loaded := getSession(t, store, sessionCookie(t, rec)); loaded.Values["keep"] != "kept" {
+			t.Errorf("regenerated session values = %v, want keep", loaded.Values)
+		}
+
+		// The old cookie gets a new, empty session
+		if old := getSession(t, store, oldCookie); !old.IsNew || len(old.Values) != 0 {
+			t.Errorf("session for the old cookie = %+v, want a new one", old)
+		}
+	})
+
+	t.Run("delete user session", func(t *testing.T) {
+		store := newTestStore(backend)
+		alice, bob := newID(), newID()
+		aliceID := newID()
+		if err := backend.Save(ctx, aliceID, Info{UserID: alice}, []byte("data"), expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		if err := backend.Save(ctx, newID(), Info{UserID: bob}, []byte("data"), expiresAt); err != nil {
+			t.Fatal(err)
+		}
+
+		// Users can only delete their own sessions
+		if err := store.DeleteUserSession(ctx, bob, handle(aliceID)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteUserSession() of another user's session error = %v, want ErrNotFound", err)
+		}
+		if _, err := backend.Load(ctx, aliceID); err != nil {
+			t.Errorf("Load() after another user tried to delete it error = %v", err)
+		}
+
+		if err := store.DeleteUserSession(ctx, alice, handle(aliceID)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := backend.Load(ctx, aliceID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Load() after DeleteUserSession() error = %v, want ErrNotFound", err)
+		}
+
+		deleted, err := store.DeleteUserSessions(ctx, bob)
+		if err != nil || deleted != 1 {
+			t.Errorf("DeleteUserSessions() = %d, %v, want 1", deleted, err)
+		}
+	})
+}
+
+func sameInfo(a, b Info) bool {
+	return a.UserID == b.UserID &&
+		a.CreatedAt.Equal(b.CreatedAt) &&
+		a.LastSeenAt.Equal(b.LastSeenAt) &&
+		a.IP == b.IP &&
+		a.UserAgent == b.UserAgent &&
+		a.ProviderSessionID == b.ProviderSessionID
+}
+
+func newTestStore(backend Backend) *Store {
+	return New(backend, securecookie.GenerateRandomKey(32), securecookie.GenerateRandomKey(32))
+}
+
+// getSession gets the session for a request with the cookie, if it isn't nil.
+func getSession(t *testing.T, store *Store, cookie *http.Cookie) *sessions.Session {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	sess, err := store.New(req, testSessionName)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return sess
+}
+
+// sessionCookie returns the session cookie the response set.
+func sessionCookie(t *testing.T, rec *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == testSessionName {
+			return c
+		}
+	}
+	t.Fatal("the session cookie wasn't set")
+	return nil
+}
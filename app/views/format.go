@@ -0,0 +1,12 @@
+package views
+
+import "time"
+
+// formatTime formats times for display, in UTC as we don't know the user's
+// time zone.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format("2 Jan 2006 15:04 MST")
+}
@@ -8,21 +8,7 @@ package views
 import "github.com/a-h/templ"
 import templruntime "github.com/a-h/templ/runtime"
 
-import (
-	"fmt"
-	"os"
-
-	"echo-cognito-auth/models"
-)
-
-func cognitoLoginURL() string {
-	baseURL := os.Getenv("COGNITO_BASE_URL")
-	clientID := os.Getenv("COGNITO_CLIENT_ID")
-	redirectURL := os.Getenv("COGNITO_REDIRECT_URI")
-
-	return fmt.Sprintf("%s/login?client_id=%s&response_type=code&scope=email+openid&redirect_uri=%s/auth/cognito/callback",
-		baseURL, clientID, redirectURL)
-}
+import "echo-cognito-auth/models"
 
 func navbar(u *models.User) templ.Component {
 	return templruntime.GeneratedTemplate(func(templ_7745c5c3_Input templruntime.GeneratedComponentInput) (templ_7745c5c3_Err error) {
@@ -57,7 +43,7 @@ func navbar(u *models.User) templ.Component {
 			var templ_7745c5c3_Var2 string
 			templ_7745c5c3_Var2, templ_7745c5c3_Err = templ.JoinStringErrs(u.Name)
 			if templ_7745c5c3_Err != nil {
-				return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/navbar.templ`, Line: 28, Col: 37}
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `views/navbar.templ`, Line: 14, Col: 37}
 			}
 			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var2))
 			if templ_7745c5c3_Err != nil {
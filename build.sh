@@ -12,6 +12,9 @@ FUNCTIONS=(
   "cognitotriggers/postconfirmation"
 )
 ROOT_DIR="`pwd`"
+# Build info for the app's /version route
+GIT_COMMIT="`git rev-parse --short HEAD 2>/dev/null || echo unknown`"
+BUILD_TIME="`date -u +%Y-%m-%dT%H:%M:%SZ`"
 BIN_DIR="${ROOT_DIR}/bin/"
 ZIP_DIR="${ROOT_DIR}/dist/"
 
@@ -33,14 +36,14 @@ do
     if [ "${STAGE}" != "local" ];
     then
       echo "Testing ${func}..."
-      go test
+      go test ./...
     fi
   fi
 
   echo "Compiling ${func} Go code"
   OUTPUT_DIR="${BIN_DIR}/${func}"
   mkdir -p $OUTPUT_DIR
-  GOOS=linux GOARCH=arm64 go build -tags lambda.norpc -ldflags="-s -w -X main.LambdaStage=${STAGE}" -o "${OUTPUT_DIR}/bootstrap"
+  GOOS=linux GOARCH=arm64 go build -tags lambda.norpc -ldflags="-s -w -X main.LambdaStage=${STAGE} -X main.GitCommit=${GIT_COMMIT} -X main.BuildTime=${BUILD_TIME}" -o "${OUTPUT_DIR}/bootstrap"
   popd > /dev/null
 
   zipfile=${ZIP_DIR}${func/\//}.zip